@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Resolver looks up the A and AAAA records for ANAME targets (stored as
+// dns.TypeMD records) on the configured upstream resolvers and caches the
+// results for their TTL.
+type Resolver struct {
+	servers   func() []string
+	client    *dns.Client
+	tcpClient *dns.Client
+
+	mu    sync.Mutex
+	cache map[string]*resolverCacheEntry
+}
+
+type resolverCacheEntry struct {
+	rrs     []dns.RR
+	err     error
+	expires time.Time
+}
+
+const (
+	// how long to cache failures and empty answers
+	resolverNegativeTtl = 30
+	// the most entries in the cache; expired entries are cleaned first,
+	// then random ones
+	resolverCacheSize = 10000
+	// client subnets sent upstream are truncated to these prefix lengths
+	resolverSubnetV4 = 24
+	resolverSubnetV6 = 56
+)
+
+// NewResolver returns a resolver using the upstream servers ("ip:port")
+// returned by the servers function (so configuration changes are picked
+// up without restarting).
+func NewResolver(servers func() []string) *Resolver {
+	return &Resolver{
+		servers: servers,
+		client: &dns.Client{
+			DialTimeout:  time.Second,
+			ReadTimeout:  time.Second,
+			WriteTimeout: time.Second,
+		},
+		tcpClient: &dns.Client{
+			Net:          "tcp",
+			DialTimeout:  time.Second,
+			ReadTimeout:  time.Second,
+			WriteTimeout: time.Second,
+		},
+		cache: make(map[string]*resolverCacheEntry),
+	}
+}
+
+// Resolve returns the records of type qtype for name, with the TTLs
+// adjusted to the remaining cache time. If ip is set it's sent to the
+// upstream server as the (truncated) EDNS client subnet.
+func (r *Resolver) Resolve(name string, qtype uint16, ip net.IP) ([]dns.RR, error) {
+	name = dns.Fqdn(name)
+
+	var subnet *net.IPNet
+	if ip != nil && !ip.IsLoopback() {
+		if ip4 := ip.To4(); ip4 != nil {
+			subnet = &net.IPNet{IP: ip4, Mask: net.CIDRMask(resolverSubnetV4, 32)}
+		} else {
+			subnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(resolverSubnetV6, 128)}
+		}
+		subnet.IP = subnet.IP.Mask(subnet.Mask)
+	}
+
+	// answers to queries with a client subnet and a scope of 0 in the
+	// response are cached for everyone, so try that first
+	globalKey := resolverCacheKey(name, qtype, "*")
+	key := resolverCacheKey(name, qtype, "-")
+	if subnet != nil {
+		key = resolverCacheKey(name, qtype, subnet.String())
+		if rrs, ok, err := r.cached(globalKey); ok {
+			return rrs, err
+		}
+	}
+	if rrs, ok, err := r.cached(key); ok {
+		return rrs, err
+	}
+
+	rrs, ttl, scope, err := r.exchange(name, qtype, subnet)
+	if err != nil {
+		r.store(key, nil, err, resolverNegativeTtl)
+		return nil, err
+	}
+
+	if subnet != nil && scope == 0 {
+		key = globalKey
+	}
+	r.store(key, rrs, nil, ttl)
+
+	return rrs, nil
+}
+
+func resolverCacheKey(name string, qtype uint16, subnet string) string {
+	return name + " " + strconv.Itoa(int(qtype)) + " " + subnet
+}
+
+func (r *Resolver) cached(key string) ([]dns.RR, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.cache[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	remaining := entry.expires.Sub(time.Now())
+	if remaining <= 0 {
+		delete(r.cache, key)
+		return nil, false, nil
+	}
+	if entry.err != nil {
+		return nil, true, entry.err
+	}
+
+	rrs := make([]dns.RR, len(entry.rrs))
+	for i, rr := range entry.rrs {
+		rrs[i] = dns.Copy(rr)
+		rrs[i].Header().Ttl = uint32(remaining.Seconds() + 0.5)
+	}
+	return rrs, true, nil
+}
+
+func (r *Resolver) store(key string, rrs []dns.RR, err error, ttl uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	if len(r.cache) >= resolverCacheSize {
+		for k, entry := range r.cache {
+			if now.After(entry.expires) {
+				delete(r.cache, k)
+			}
+		}
+		// still full, the map order is random enough
+		for k := range r.cache {
+			if len(r.cache) < resolverCacheSize {
+				break
+			}
+			delete(r.cache, k)
+		}
+	}
+
+	r.cache[key] = &resolverCacheEntry{
+		rrs:     rrs,
+		err:     err,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// exchange queries the upstream servers in order until one of them gives
+// an answer. It returns the records of type qtype, the lowest TTL in the
+// answer and the ECS scope of the response.
+func (r *Resolver) exchange(name string, qtype uint16, subnet *net.IPNet) ([]dns.RR, uint32, uint8, error) {
+	var servers []string
+	if r.servers != nil {
+		servers = r.servers()
+	}
+	if len(servers) == 0 {
+		return nil, 0, 0, fmt.Errorf("no upstream resolvers configured")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.SetEdns0(4096, false)
+
+	if subnet != nil {
+		e := new(dns.EDNS0_SUBNET)
+		e.Code = dns.EDNS0SUBNET
+		e.Address = subnet.IP
+		ones, _ := subnet.Mask.Size()
+		e.SourceNetmask = uint8(ones)
+		e.Family = 1
+		if subnet.IP.To4() == nil {
+			e.Family = 2
+		}
+		opt := msg.IsEdns0()
+		opt.Option = append(opt.Option, e)
+	}
+
+	var lastErr error
+
+	for _, server := range servers {
+		resp, _, err := r.client.Exchange(msg, server)
+		if (err == nil && resp.Truncated) || err == dns.ErrTruncated {
+			resp, _, err = r.tcpClient.Exchange(msg, server)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%s returned %s for %s", server, dns.RcodeToString[resp.Rcode], name)
+			continue
+		}
+
+		ttl := uint32(0)
+		var rrs []dns.RR
+
+		for i, rr := range resp.Answer {
+			// CNAMEs on the way to the records also limit the TTL
+			if i == 0 || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+			if rr.Header().Rrtype == qtype {
+				rrs = append(rrs, rr)
+			}
+		}
+		if len(rrs) == 0 {
+			ttl = resolverNegativeTtl
+		}
+
+		var scope uint8
+		if opt := resp.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+					scope = e.SourceScope
+				}
+			}
+		}
+
+		return rrs, ttl, scope, nil
+	}
+
+	return nil, 0, 0, lastErr
+}
+
+// resolveANAME returns the records of type qtype for the target of the
+// ANAME record in label, named as the query and with the TTL capped to the
+// TTL of the label.
+func (srv *Server) resolveANAME(label *Label, name string, qtype uint16, ip net.IP) ([]dns.RR, error) {
+	if srv.resolver == nil {
+		return nil, fmt.Errorf("no resolver for ANAME records")
+	}
+
+	record := label.Picker(dns.TypeMD, 1)[0]
+	target := record.RR.(*dns.MD).Md
+
+	rrs, err := srv.resolver.Resolve(target, qtype, ip)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve ANAME target %s: %s", target, err)
+	}
+
+	answer := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		if ttl := record.RR.Header().Ttl; ttl > 0 && rr.Header().Ttl > ttl {
+			rr.Header().Ttl = ttl
+		}
+		answer[i] = rr
+	}
+	return answer, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+	. "gopkg.in/check.v1"
+)
+
+const (
+	STUBRESOLVER = "127.0.0.1:8854"
+)
+
+type ResolverSuite struct {
+}
+
+var _ = Suite(&ResolverSuite{})
+
+// startStubResolver starts a DNS server answering for cdn.example.net, with
+// a different answer for clients in 194.239.134.0/24 (as given by EDNS
+// client subnet), and SERVFAIL for anything else.
+func startStubResolver(addr string) {
+	mux := dns.NewServeMux()
+	mux.HandleFunc("cdn.example.net.", func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+
+		var ecs *dns.EDNS0_SUBNET
+		if opt := req.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+					ecs = e
+				}
+			}
+		}
+
+		h := dns.RR_Header{Name: req.Question[0].Name, Class: dns.ClassINET, Ttl: 30}
+		switch req.Question[0].Qtype {
+		case dns.TypeA:
+			h.Rrtype = dns.TypeA
+			ip := "192.0.2.10"
+			if ecs != nil && ecs.Address.Equal(net.ParseIP("194.239.134.0")) {
+				ip = "192.0.2.20"
+			}
+			m.Answer = []dns.RR{&dns.A{Hdr: h, A: net.ParseIP(ip)}}
+		case dns.TypeAAAA:
+			h.Rrtype = dns.TypeAAAA
+			m.Answer = []dns.RR{&dns.AAAA{Hdr: h, AAAA: net.ParseIP("2001:db8::10")}}
+		}
+
+		if ecs != nil {
+			ecs.SourceScope = ecs.SourceNetmask
+			m.SetEdns0(4096, false)
+			opt := m.IsEdns0()
+			opt.Option = append(opt.Option, ecs)
+		}
+		w.WriteMsg(m)
+	})
+	// truncated over UDP
+	mux.HandleFunc("big.example.net.", func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			m.Truncated = true
+		} else {
+			h := dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30}
+			m.Answer = []dns.RR{&dns.A{Hdr: h, A: net.ParseIP("192.0.2.30")}}
+		}
+		w.WriteMsg(m)
+	})
+	mux.HandleFunc(".", func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	})
+
+	for _, p := range []string{"udp", "tcp"} {
+		server := &dns.Server{Addr: addr, Net: p, Handler: mux}
+		go server.ListenAndServe()
+	}
+	time.Sleep(100 * time.Millisecond)
+}
+
+// testResolver returns a resolver using the stub resolver. The zones in the
+// tests are all loaded into the shared dns.ServeMux, so every suite
+// loading them needs to set it up the same way.
+func testResolver() *Resolver {
+	return NewResolver(func() []string { return []string{STUBRESOLVER} })
+}
+
+func (s *ResolverSuite) SetUpSuite(c *C) {
+	startStubResolver("127.0.0.1:8855")
+}
+
+func (s *ResolverSuite) TestResolve(c *C) {
+	r := NewResolver(func() []string { return []string{"127.0.0.1:8855"} })
+
+	rrs, err := r.Resolve("cdn.example.net", dns.TypeA, nil)
+	c.Assert(err, IsNil)
+	c.Assert(rrs, HasLen, 1)
+	c.Check(rrs[0].(*dns.A).A.String(), Equals, "192.0.2.10")
+	c.Check(int(rrs[0].Header().Ttl), Equals, 30)
+
+	// from the cache
+	rrs, err = r.Resolve("cdn.example.net", dns.TypeA, nil)
+	c.Assert(err, IsNil)
+	c.Assert(rrs, HasLen, 1)
+	c.Check(rrs[0].Header().Ttl <= 30, Equals, true)
+
+	// the client subnet is sent upstream and cached separately
+	rrs, err = r.Resolve("cdn.example.net", dns.TypeA, net.ParseIP("194.239.134.1"))
+	c.Assert(err, IsNil)
+	c.Assert(rrs, HasLen, 1)
+	c.Check(rrs[0].(*dns.A).A.String(), Equals, "192.0.2.20")
+	c.Check(r.cache, HasLen, 2)
+
+	rrs, err = r.Resolve("cdn.example.net", dns.TypeAAAA, net.ParseIP("194.239.134.1"))
+	c.Assert(err, IsNil)
+	c.Assert(rrs, HasLen, 1)
+	c.Check(rrs[0].(*dns.AAAA).AAAA.String(), Equals, "2001:db8::10")
+
+	// errors are cached, too
+	_, err = r.Resolve("fail.example.net", dns.TypeA, nil)
+	c.Check(err, NotNil)
+	_, err = r.Resolve("fail.example.net", dns.TypeA, nil)
+	c.Check(err, NotNil)
+
+	// truncated answers are retried over TCP
+	rrs, err = r.Resolve("big.example.net", dns.TypeA, nil)
+	c.Assert(err, IsNil)
+	c.Assert(rrs, HasLen, 1)
+	c.Check(rrs[0].(*dns.A).A.String(), Equals, "192.0.2.30")
+
+	r = NewResolver(func() []string { return nil })
+	_, err = r.Resolve("cdn.example.net", dns.TypeA, nil)
+	c.Check(err, ErrorMatches, "no upstream resolvers configured")
+}
+
+func (s *ResolverSuite) TestResolverCacheSize(c *C) {
+	r := NewResolver(nil)
+	for i := 0; i < resolverCacheSize+100; i++ {
+		r.store(resolverCacheKey("cdn.example.net.", dns.TypeA, strconv.Itoa(i)), nil, nil, 300)
+	}
+	c.Check(len(r.cache) <= resolverCacheSize, Equals, true)
+	_, ok, _ := r.cached(resolverCacheKey("cdn.example.net.", dns.TypeA, strconv.Itoa(resolverCacheSize+99)))
+	c.Check(ok, Equals, true)
+}
+
+func (s *ServeSuite) TestServingANAME(c *C) {
+	r := exchange(c, "aname.test.example.com.", dns.TypeA)
+	c.Assert(r.Answer, HasLen, 1)
+	c.Check(r.Answer[0].(*dns.A).A.String(), Equals, "192.0.2.10")
+	c.Check(r.Answer[0].Header().Name, Equals, "aname.test.example.com.")
+	// capped to the TTL of the label
+	c.Check(int(r.Answer[0].Header().Ttl), Equals, 20)
+	c.Check(r.Authoritative, Equals, true)
+
+	r = exchange(c, "aname.test.example.com.", dns.TypeAAAA)
+	c.Assert(r.Answer, HasLen, 1)
+	c.Check(r.Answer[0].(*dns.AAAA).AAAA.String(), Equals, "2001:db8::10")
+
+	// the client subnet is forwarded
+	r = exchangeSubnet(c, "aname.test.example.com.", dns.TypeA, "194.239.134.1")
+	c.Assert(r.Answer, HasLen, 1)
+	c.Check(r.Answer[0].(*dns.A).A.String(), Equals, "192.0.2.20")
+
+	// the MD records used internally aren't returned
+	r = exchange(c, "aname.test.example.com.", dns.TypeANY)
+	c.Check(r.Answer, HasLen, 0)
+
+	// local records are used if the target can't be resolved
+	r = exchange(c, "aname-fallback.test.example.com.", dns.TypeA)
+	c.Assert(r.Answer, HasLen, 1)
+	c.Check(r.Answer[0].(*dns.A).A.String(), Equals, "192.168.1.9")
+
+	r = exchange(c, "aname-fail.test.example.com.", dns.TypeA)
+	c.Check(r.Rcode, Equals, dns.RcodeServerFailure)
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/miekg/dns"
 	"gopkg.in/fsnotify.v1"
 	"gopkg.in/gcfg.v1"
 )
@@ -30,6 +32,44 @@ type AppConfig struct {
 		MaxSize int
 		Keep    int
 	}
+	ANAME struct {
+		Resolver []string
+	}
+	Serial struct {
+		StateFile string
+	}
+	TSIG map[string]*struct {
+		Secret    string
+		Algorithm string
+	}
+	Secondary map[string]*SecondaryConfig
+	HTTPZones HTTPZonesConfig
+	KVZones   KVZonesConfig
+}
+
+// KVZonesConfig is the configuration of the zones read from a key-value
+// store (etcd or Consul).
+type KVZonesConfig struct {
+	Type   string
+	URL    []string
+	Prefix string
+}
+
+// HTTPZonesConfig is the configuration of the zones read from HTTP(S) URLs.
+type HTTPZonesConfig struct {
+	URL      []string
+	Interval int
+	Cache    string
+}
+
+// SecondaryConfig is the configuration of a secondary zone, a zone that is
+// transferred from another DNS server (the primary).
+type SecondaryConfig struct {
+	Primary []string
+	TSIG    string
+	Refresh int
+	Notify  bool
+	File    string
 }
 
 var Config = new(AppConfig)
@@ -53,6 +93,91 @@ func (conf *AppConfig) GeoIPDirectory() string {
 	return conf.GeoIP.Directory
 }
 
+func (conf *AppConfig) ANAMEResolvers() []string {
+	cfgMutex.RLock()
+	defer cfgMutex.RUnlock()
+	return conf.ANAME.Resolver
+}
+
+func (conf *AppConfig) SerialStateFile() string {
+	cfgMutex.RLock()
+	defer cfgMutex.RUnlock()
+	return conf.Serial.StateFile
+}
+
+// tsigAlgorithms are the TSIG algorithms that can be set for a key with
+// "algorithm"
+var tsigAlgorithms = map[string]string{
+	"hmac-md5":                 dns.HmacMD5,
+	"hmac-md5.sig-alg.reg.int": dns.HmacMD5,
+	"hmac-sha1":                dns.HmacSHA1,
+	"hmac-sha256":              dns.HmacSHA256,
+	"hmac-sha512":              dns.HmacSHA512,
+}
+
+// TsigSecrets returns the TSIG secrets by (fully qualified) key name. Keys
+// with an unknown algorithm are left out.
+func (conf *AppConfig) TsigSecrets() map[string]string {
+	cfgMutex.RLock()
+	defer cfgMutex.RUnlock()
+	secrets := make(map[string]string, len(conf.TSIG))
+	for name, key := range conf.TSIG {
+		if _, ok := tsigAlgorithm(key.Algorithm); !ok {
+			continue
+		}
+		secrets[dns.Fqdn(strings.ToLower(name))] = key.Secret
+	}
+	return secrets
+}
+
+// TsigAlgorithms returns the TSIG algorithms (for the requests and NOTIFYs
+// geodns signs) by (fully qualified) key name, hmac-sha256 if it isn't set.
+func (conf *AppConfig) TsigAlgorithms() map[string]string {
+	cfgMutex.RLock()
+	defer cfgMutex.RUnlock()
+	algorithms := make(map[string]string, len(conf.TSIG))
+	for name, key := range conf.TSIG {
+		algorithm, ok := tsigAlgorithm(key.Algorithm)
+		if !ok {
+			log.Printf("TSIG key %s: unknown algorithm '%s', the key isn't used", name, key.Algorithm)
+			continue
+		}
+		algorithms[dns.Fqdn(strings.ToLower(name))] = algorithm
+	}
+	return algorithms
+}
+
+func tsigAlgorithm(name string) (string, bool) {
+	if len(name) == 0 {
+		return dns.HmacSHA256, true
+	}
+	algorithm, ok := tsigAlgorithms[strings.ToLower(strings.TrimSuffix(name, "."))]
+	return algorithm, ok
+}
+
+// SecondaryZones returns the secondary zone configurations by zone name.
+func (conf *AppConfig) SecondaryZones() map[string]SecondaryConfig {
+	cfgMutex.RLock()
+	defer cfgMutex.RUnlock()
+	zones := make(map[string]SecondaryConfig, len(conf.Secondary))
+	for name, zone := range conf.Secondary {
+		zones[strings.ToLower(strings.TrimSuffix(name, "."))] = *zone
+	}
+	return zones
+}
+
+func (conf *AppConfig) HTTPZoneSource() HTTPZonesConfig {
+	cfgMutex.RLock()
+	defer cfgMutex.RUnlock()
+	return conf.HTTPZones
+}
+
+func (conf *AppConfig) KVZoneSource() KVZonesConfig {
+	cfgMutex.RLock()
+	defer cfgMutex.RUnlock()
+	return conf.KVZones
+}
+
 func configWatcher(fileName string) {
 
 	watcher, err := fsnotify.NewWatcher()
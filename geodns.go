@@ -179,6 +179,14 @@ func main() {
 		srv.SetQueryLogger(ql)
 	}
 
+	srv.SetResolver(NewResolver(Config.ANAMEResolvers))
+	srv.SetTsigSecrets(Config.TsigSecrets())
+	srv.SetTsigAlgorithms(Config.TsigAlgorithms())
+	// after the TSIG secrets, the secondary zones can use them
+	srv.SetSecondaries(Config.SecondaryZones())
+	srv.SetHTTPZones(Config.HTTPZoneSource())
+	srv.SetKVZones(Config.KVZoneSource())
+
 	if *flaginter == "*" {
 		addrs, _ := net.InterfaceAddrs()
 		ips := make([]string, 0)
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Zones can be read from HTTP(S) URLs, configured in the configuration file:
+//
+//	[httpzones]
+//	url = https://zones.example.com/geodns/index.json
+//	interval = 60
+//	cache = /var/lib/geodns/httpzones
+//
+// The index is a JSON list of the zone file URLs (relative to the index
+// URL), with the zone files named like in the zone directory:
+//
+//	[ "example.com.json", "example.net.yaml", "https://example.org/example.org.json" ]
+//
+// The index and the zone files are checked every "interval" seconds with
+// conditional requests (If-None-Match and If-Modified-Since). A changed zone
+// file is only used when it can be read (like a file in the zone directory,
+// with the Last-Modified time as the modification time); the last good
+// copies are kept in the "cache" directory and read at startup, so the zones
+// can be served when the server with the zones can't be reached.
+
+// httpZonesInterval is the default time between checks for changes
+const httpZonesInterval = 60 * time.Second
+
+// httpZonesTimeout is how long a request can take
+var httpZonesTimeout = 30 * time.Second
+
+// httpZonesMaxSize is the largest index or zone file that is read
+var httpZonesMaxSize int64 = 64 << 20
+
+type httpZoneSource struct {
+	indexes  []string
+	interval time.Duration
+	cache    string
+	client   *http.Client
+
+	// the ETag and Last-Modified header of the last response for each URL
+	validators map[string]httpValidators
+	// the zone files from each index
+	indexFiles map[string][]httpZoneFile
+	// the cache file (in the cache directory) by zone name
+	files map[string]string
+}
+
+type httpValidators struct {
+	etag         string
+	lastModified string
+}
+
+type httpZoneFile struct {
+	zoneName string
+	url      string
+	file     string
+}
+
+// SetHTTPZones sets up the zones read from HTTP(S) URLs. The zones are read
+// when the zonesReader is started.
+func (srv *Server) SetHTTPZones(conf HTTPZonesConfig) {
+	if len(conf.URL) == 0 {
+		srv.httpZones = nil
+		return
+	}
+	s := &httpZoneSource{
+		indexes:    conf.URL,
+		interval:   time.Duration(conf.Interval) * time.Second,
+		cache:      conf.Cache,
+		client:     &http.Client{Timeout: httpZonesTimeout},
+		validators: make(map[string]httpValidators),
+		indexFiles: make(map[string][]httpZoneFile),
+		files:      make(map[string]string),
+	}
+	if s.interval <= 0 {
+		s.interval = httpZonesInterval
+	}
+	srv.httpZones = s
+}
+
+func (s *httpZoneSource) run(updates chan<- zoneUpdate) {
+	if err := s.setupCache(); err != nil {
+		log.Printf("Could not create the HTTP zones cache, the HTTP zones aren't read: %s", err)
+		return
+	}
+	s.readCache(updates)
+	for {
+		s.poll(updates)
+		time.Sleep(s.interval)
+	}
+}
+
+// setupCache creates the cache directory, or a temporary directory if
+// there's no cache directory in the configuration.
+func (s *httpZoneSource) setupCache() error {
+	if len(s.cache) == 0 {
+		dir, err := ioutil.TempDir("", "geodns-httpzones.")
+		if err != nil {
+			return err
+		}
+		s.cache = dir
+		return nil
+	}
+	return os.MkdirAll(s.cache, 0755)
+}
+
+// readCache reads the last good copies of the zones.
+func (s *httpZoneSource) readCache(updates chan<- zoneUpdate) {
+	dir, err := ioutil.ReadDir(s.cache)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Could not read the HTTP zones cache: %s", err)
+		}
+		return
+	}
+	for _, file := range dir {
+		fileName := file.Name()
+		if !isZoneFile(fileName) || strings.HasPrefix(fileName, ".") || file.IsDir() {
+			continue
+		}
+		zoneName := zoneNameFromFile(fileName)
+		if _, ok := s.files[zoneName]; ok {
+			log.Printf("Ignoring %s, there's another file for %s", fileName, zoneName)
+			continue
+		}
+		zone, err := readZoneFile(zoneName, filepath.Join(s.cache, fileName))
+		if zone == nil || err != nil {
+			log.Printf("Error reading zone '%s': %s", zoneName, err)
+			continue
+		}
+		s.files[zoneName] = fileName
+		updates <- zoneUpdate{name: zoneName, zone: zone}
+	}
+}
+
+// poll checks the indexes and zone files for changes. The zones that aren't
+// in the indexes anymore are only removed when all the indexes could be
+// read.
+func (s *httpZoneSource) poll(updates chan<- zoneUpdate) {
+	zoneFiles := make(map[string]httpZoneFile)
+	complete := true
+
+	for _, index := range s.indexes {
+		files, err := s.readIndex(index)
+		if err != nil {
+			log.Printf("Could not read the zone index %s: %s", index, err)
+			complete = false
+			continue
+		}
+		for _, zf := range files {
+			if other, ok := zoneFiles[zf.zoneName]; ok {
+				if other.url != zf.url {
+					log.Printf("Ignoring %s, there's another file for %s (%s)", zf.url, zf.zoneName, other.url)
+				}
+				continue
+			}
+			zoneFiles[zf.zoneName] = zf
+		}
+	}
+
+	names := make([]string, 0, len(zoneFiles))
+	for name := range zoneFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s.updateZone(zoneFiles[name], updates)
+	}
+
+	if !complete {
+		return
+	}
+	for name, fileName := range s.files {
+		if _, ok := zoneFiles[name]; ok {
+			continue
+		}
+		os.Remove(filepath.Join(s.cache, fileName))
+		delete(s.files, name)
+		updates <- zoneUpdate{name: name}
+	}
+}
+
+// readIndex returns the zone files in the index.
+func (s *httpZoneSource) readIndex(index string) ([]httpZoneFile, error) {
+	body, v, err := s.fetch(index)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return s.indexFiles[index], nil
+	}
+
+	base, err := url.Parse(index)
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("the index should be a list of URLs: %s", err)
+	}
+
+	files := make([]httpZoneFile, 0, len(list))
+	for _, entry := range list {
+		u, err := base.Parse(entry)
+		if err != nil {
+			return nil, err
+		}
+		fileName := path.Base(u.Path)
+		if !isZoneFile(fileName) || strings.HasPrefix(fileName, ".") {
+			return nil, fmt.Errorf("'%s' isn't a zone file", entry)
+		}
+		files = append(files, httpZoneFile{zoneNameFromFile(fileName), u.String(), fileName})
+	}
+
+	s.indexFiles[index] = files
+	s.validators[index] = *v
+	return files, nil
+}
+
+// updateZone reads the zone file if it changed.
+func (s *httpZoneSource) updateZone(zf httpZoneFile, updates chan<- zoneUpdate) {
+	body, v, err := s.fetch(zf.url)
+	if err != nil {
+		log.Printf("Could not read zone '%s': %s", zf.zoneName, err)
+		return
+	}
+	if body == nil {
+		return
+	}
+
+	cacheFile := filepath.Join(s.cache, zf.file)
+	if old, err := ioutil.ReadFile(cacheFile); err == nil && bytes.Equal(old, body) && s.files[zf.zoneName] == zf.file {
+		s.validators[zf.url] = *v
+		return
+	}
+
+	// read the new copy before replacing the old one
+	tmpFile := filepath.Join(s.cache, "."+zf.file)
+	if err := ioutil.WriteFile(tmpFile, body, 0644); err != nil {
+		log.Printf("Could not save zone '%s': %s", zf.zoneName, err)
+		return
+	}
+	modTime := time.Now()
+	if t, err := http.ParseTime(v.lastModified); err == nil {
+		modTime = t
+	}
+	os.Chtimes(tmpFile, modTime, modTime)
+
+	zone, err := readZoneFile(zf.zoneName, tmpFile)
+	if zone == nil || err != nil {
+		log.Printf("Error reading zone '%s' from %s: %s", zf.zoneName, zf.url, err)
+		os.Remove(tmpFile)
+		// not read again until it changes
+		s.validators[zf.url] = *v
+		return
+	}
+	if err := os.Rename(tmpFile, cacheFile); err != nil {
+		log.Printf("Could not save zone '%s': %s", zf.zoneName, err)
+		os.Remove(tmpFile)
+	}
+	if old, ok := s.files[zf.zoneName]; ok && old != zf.file {
+		os.Remove(filepath.Join(s.cache, old))
+	}
+	s.files[zf.zoneName] = zf.file
+	s.validators[zf.url] = *v
+
+	logPrintf("Read zone %s from %s\n", zf.zoneName, zf.url)
+	updates <- zoneUpdate{name: zf.zoneName, zone: zone}
+}
+
+// fetch gets the URL if it changed since the last time (the body is nil if
+// it didn't).
+func (s *httpZoneSource) fetch(u string) ([]byte, *httpValidators, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v, ok := s.validators[u]; ok {
+		if len(v.etag) > 0 {
+			req.Header.Set("If-None-Match", v.etag)
+		}
+		if len(v.lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", v.lastModified)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpZonesMaxSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(body)) > httpZonesMaxSize {
+		return nil, nil, fmt.Errorf("%s: larger than %d bytes", u, httpZonesMaxSize)
+	}
+	return body, &httpValidators{resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")}, nil
+}
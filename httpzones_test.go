@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+
+	"github.com/miekg/dns"
+	. "gopkg.in/check.v1"
+)
+
+type HTTPZonesSuite struct {
+}
+
+var _ = Suite(&HTTPZonesSuite{})
+
+// zoneOrigin is an HTTP server for zone files with ETags.
+type zoneOrigin struct {
+	sync.Mutex
+	files    map[string]string
+	requests map[int]int
+}
+
+func (o *zoneOrigin) set(name, content string) {
+	o.Lock()
+	defer o.Unlock()
+	if len(content) == 0 {
+		delete(o.files, name)
+		return
+	}
+	o.files[name] = content
+}
+
+func (o *zoneOrigin) counts() map[int]int {
+	o.Lock()
+	defer o.Unlock()
+	counts := o.requests
+	o.requests = make(map[int]int)
+	return counts
+}
+
+func (o *zoneOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.Lock()
+	defer o.Unlock()
+
+	content, ok := o.files[r.URL.Path]
+	if !ok {
+		o.requests[http.StatusNotFound]++
+		http.NotFound(w, r)
+		return
+	}
+	sum := sha256.Sum256([]byte(content))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		o.requests[http.StatusNotModified]++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	o.requests[http.StatusOK]++
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", "Mon, 01 Feb 2016 12:00:00 GMT")
+	w.Write([]byte(content))
+}
+
+func (s *HTTPZonesSuite) TestHTTPZones(c *C) {
+	origin := &zoneOrigin{files: make(map[string]string), requests: make(map[int]int)}
+	ts := httptest.NewServer(origin)
+	defer ts.Close()
+
+	origin.set("/zones/index.json", `[ "a.example.com.json", "/other/b.example.com.yaml" ]`)
+	origin.set("/zones/a.example.com.json", `{ "data": { "": { "ns": [ "ns1.example.net." ] }, "www": { "a": [ "192.0.2.1" ] } } }`)
+	origin.set("/other/b.example.com.yaml", "data:\n  \"\":\n    ns: [ ns1.example.net. ]\n")
+
+	cache := c.MkDir()
+	srv := &Server{}
+	srv.SetHTTPZones(HTTPZonesConfig{URL: []string{ts.URL + "/zones/index.json"}, Cache: cache})
+	source := srv.httpZones
+	c.Assert(source, NotNil)
+	c.Check(source.interval, Equals, httpZonesInterval)
+
+	updates := make(chan zoneUpdate, 10)
+	readUpdates := func() map[string]*Zone {
+		zones := make(map[string]*Zone)
+		for len(updates) > 0 {
+			u := <-updates
+			zones[u.name] = u.zone
+		}
+		return zones
+	}
+
+	source.poll(updates)
+	zones := readUpdates()
+	c.Assert(zones, HasLen, 2)
+	c.Assert(zones["a.example.com"], NotNil)
+	c.Check(zones["a.example.com"].Labels["www"].firstRR(dns.TypeA).String(), Matches, ".*192.0.2.1")
+	// the serial is from the Last-Modified time
+	c.Check(zones["a.example.com"].Options.Serial, Equals, 1454328000)
+	c.Check(zones["b.example.com"], NotNil)
+	c.Check(origin.counts(), DeepEquals, map[int]int{http.StatusOK: 3})
+
+	// nothing changed
+	source.poll(updates)
+	c.Check(readUpdates(), HasLen, 0)
+	c.Check(origin.counts(), DeepEquals, map[int]int{http.StatusNotModified: 3})
+
+	// a zone file that can't be read isn't used (and not read again)
+	origin.set("/zones/a.example.com.json", `{ "data": { "www": { "a": [ "192.0.2.x" ] } } }`)
+	source.poll(updates)
+	source.poll(updates)
+	c.Check(readUpdates(), HasLen, 0)
+	c.Check(origin.counts(), DeepEquals, map[int]int{http.StatusOK: 1, http.StatusNotModified: 5})
+	data, err := ioutil.ReadFile(cache + "/a.example.com.json")
+	c.Assert(err, IsNil)
+	c.Check(string(data), Matches, ".*192.0.2.1.*")
+
+	origin.set("/zones/a.example.com.json", `{ "data": { "": { "ns": [ "ns1.example.net." ] }, "www": { "a": [ "192.0.2.2" ] } } }`)
+	source.poll(updates)
+	zones = readUpdates()
+	c.Assert(zones, HasLen, 1)
+	c.Check(zones["a.example.com"].Labels["www"].firstRR(dns.TypeA).String(), Matches, ".*192.0.2.2")
+
+	// zones removed from the index are removed
+	origin.set("/zones/index.json", `[ "a.example.com.json" ]`)
+	source.poll(updates)
+	zones = readUpdates()
+	c.Assert(zones, HasLen, 1)
+	z, ok := zones["b.example.com"]
+	c.Check(ok, Equals, true)
+	c.Check(z, IsNil)
+	_, err = os.Stat(cache + "/b.example.com.yaml")
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// the cached zones are used when the index can't be read
+	origin.set("/zones/index.json", "")
+	srv.SetHTTPZones(HTTPZonesConfig{URL: []string{ts.URL + "/zones/index.json"}, Cache: cache, Interval: 10})
+	source = srv.httpZones
+	source.readCache(updates)
+	source.poll(updates)
+	zones = readUpdates()
+	c.Assert(zones, HasLen, 1)
+	c.Check(zones["a.example.com"].Labels["www"].firstRR(dns.TypeA).String(), Matches, ".*192.0.2.2")
+	c.Check(zones["a.example.com"].Options.Serial, Equals, 1454328000)
+
+	// a bad index
+	origin.set("/zones/index.json", `{ "zones": [] }`)
+	_, err = source.readIndex(ts.URL + "/zones/index.json")
+	c.Check(err, ErrorMatches, "the index should be a list of URLs: .*")
+	origin.set("/zones/index.json", `[ "index.html" ]`)
+	_, err = source.readIndex(ts.URL + "/zones/index.json")
+	c.Check(err, ErrorMatches, "'index.html' isn't a zone file")
+
+	// too large
+	defer func(size int64) { httpZonesMaxSize = size }(httpZonesMaxSize)
+	httpZonesMaxSize = 10
+	_, err = source.readIndex(ts.URL + "/zones/index.json")
+	c.Check(err, ErrorMatches, ".*/zones/index.json: larger than 10 bytes")
+}
+
+func (s *HTTPZonesSuite) TestHTTPZonesCache(c *C) {
+	srv := &Server{}
+	cache := c.MkDir() + "/var/httpzones"
+	srv.SetHTTPZones(HTTPZonesConfig{URL: []string{"http://127.0.0.1/index.json"}, Cache: cache})
+	c.Assert(srv.httpZones.setupCache(), IsNil)
+	info, err := os.Stat(cache)
+	c.Assert(err, IsNil)
+	c.Check(info.IsDir(), Equals, true)
+
+	// a file where the directory should be
+	srv.SetHTTPZones(HTTPZonesConfig{URL: []string{"http://127.0.0.1/index.json"}, Cache: cache + "/file/dir"})
+	c.Assert(ioutil.WriteFile(cache+"/file", nil, 0644), IsNil)
+	c.Check(srv.httpZones.setupCache(), NotNil)
+}
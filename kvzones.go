@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Zones can be read from a key-value store (etcd or Consul), configured in
+// the configuration file:
+//
+//	[kvzones]
+//	type = etcd
+//	url = http://127.0.0.1:2379
+//	prefix = /geodns/zones/
+//
+// Each key under the prefix is a zone document, named like the files in the
+// zone directory (the last part of the key is the file name):
+//
+//	/geodns/zones/example.com.json
+//	/geodns/zones/example.net.yaml
+//
+// The prefix is watched (with a blocking request) so changes are used right
+// away. A changed document is only used when it can be read, otherwise the
+// previous version of the zone is kept.
+
+// kvZonesWait is how long a request waits for changes; after that all the
+// documents are read again
+var kvZonesWait = 60 * time.Second
+
+// kvZonesRetry is the time before trying again when the store can't be read
+var kvZonesRetry = 5 * time.Second
+
+// A kvStore is a key-value store with the zone documents.
+type kvStore interface {
+	// list returns the values of the keys under the prefix and the index
+	// (version) of the store. With an index it first waits until
+	// something changed after it (or kvZonesWait).
+	list(prefix string, index uint64) (map[string][]byte, uint64, error)
+}
+
+type kvZoneSource struct {
+	store  kvStore
+	prefix string
+	dir    string
+
+	// the last value of each key, and the key by zone name for the
+	// installed zones
+	values map[string][]byte
+	keys   map[string]string
+}
+
+// SetKVZones sets up the zones read from a key-value store. The zones are
+// read when the zonesReader is started.
+func (srv *Server) SetKVZones(conf KVZonesConfig) {
+	srv.kvZones = nil
+	if len(conf.URL) == 0 {
+		return
+	}
+	client := &kvClient{
+		urls:   conf.URL,
+		client: &http.Client{Timeout: kvZonesWait + httpZonesTimeout},
+	}
+	var store kvStore
+	switch strings.ToLower(conf.Type) {
+	case "etcd":
+		store = &etcdStore{client}
+	case "consul":
+		store = &consulStore{client}
+	default:
+		log.Printf("Unknown key-value store type '%s' (etcd or consul)", conf.Type)
+		return
+	}
+	srv.kvZones = newKVZoneSource(store, conf.Prefix)
+}
+
+func newKVZoneSource(store kvStore, prefix string) *kvZoneSource {
+	return &kvZoneSource{
+		store:  store,
+		prefix: prefix,
+		values: make(map[string][]byte),
+		keys:   make(map[string]string),
+	}
+}
+
+func (s *kvZoneSource) run(updates chan<- zoneUpdate) {
+	var index uint64
+	for {
+		values, next, err := s.store.list(s.prefix, index)
+		if err != nil {
+			log.Printf("Could not read the zones in %s: %s", s.prefix, err)
+			index = 0
+			time.Sleep(kvZonesRetry)
+			continue
+		}
+		s.update(values, updates)
+		index = next
+	}
+}
+
+// update reads the changed zone documents and removes the zones that aren't
+// in the store anymore.
+func (s *kvZoneSource) update(values map[string][]byte, updates chan<- zoneUpdate) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	seen := make(map[string]string)
+	for _, key := range keys {
+		fileName := path.Base(key)
+		if !isZoneFile(fileName) || strings.HasPrefix(fileName, ".") {
+			continue
+		}
+		zoneName := zoneNameFromFile(fileName)
+		if other, ok := seen[zoneName]; ok {
+			if !bytes.Equal(s.values[key], values[key]) {
+				log.Printf("Ignoring %s, there's another document for %s (%s)", key, zoneName, other)
+			}
+			s.values[key] = values[key]
+			continue
+		}
+		seen[zoneName] = key
+
+		if old, ok := s.values[key]; ok && bytes.Equal(old, values[key]) {
+			if k, ok := s.keys[zoneName]; !ok || k == key {
+				continue
+			}
+		}
+		// not read again until it changes
+		s.values[key] = values[key]
+
+		zone, err := s.readZone(zoneName, fileName, values[key])
+		if zone == nil || err != nil {
+			log.Printf("Error reading zone '%s' from %s: %s", zoneName, key, err)
+			continue
+		}
+		s.keys[zoneName] = key
+		logPrintf("Read zone %s from %s\n", zoneName, key)
+		updates <- zoneUpdate{name: zoneName, zone: zone}
+	}
+
+	for key := range s.values {
+		if _, ok := values[key]; !ok {
+			delete(s.values, key)
+		}
+	}
+	for zoneName, key := range s.keys {
+		if _, ok := values[key]; ok && seen[zoneName] == key {
+			continue
+		}
+		delete(s.keys, zoneName)
+		if _, ok := seen[zoneName]; ok {
+			// the zone is in another document now, but it couldn't
+			// be read; keep the old version
+			continue
+		}
+		updates <- zoneUpdate{name: zoneName}
+	}
+}
+
+// readZone reads the zone from a document, like a file in the zone
+// directory (modified now).
+func (s *kvZoneSource) readZone(zoneName, fileName string, data []byte) (*Zone, error) {
+	if len(s.dir) == 0 {
+		dir, err := ioutil.TempDir("", "geodns-kvzones.")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+	}
+	file := filepath.Join(s.dir, fileName)
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		return nil, err
+	}
+	defer os.Remove(file)
+	return readZoneFile(zoneName, file)
+}
+
+// kvClient makes requests to the servers of a key-value store, starting
+// with the last one that answered.
+type kvClient struct {
+	urls    []string
+	current int
+	client  *http.Client
+}
+
+func (c *kvClient) get(p string, query url.Values) (*http.Response, error) {
+	return c.getContext(context.Background(), p, query)
+}
+
+func (c *kvClient) getContext(ctx context.Context, p string, query url.Values) (*http.Response, error) {
+	var err error
+	for i := 0; i < len(c.urls); i++ {
+		n := (c.current + i) % len(c.urls)
+		u := strings.TrimSuffix(c.urls[n], "/") + p + "?" + query.Encode()
+		var req *http.Request
+		req, err = http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		var resp *http.Response
+		resp, err = c.client.Do(req.WithContext(ctx))
+		if err == nil {
+			c.current = n
+			return resp, nil
+		}
+		if isTimeout(err) {
+			break
+		}
+	}
+	return nil, err
+}
+
+func isTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
+
+// responseIndex returns the store index from the header of the response.
+func responseIndex(resp *http.Response, header string) (uint64, error) {
+	index, err := strconv.ParseUint(resp.Header.Get(header), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("no %s in the response", header)
+	}
+	return index, nil
+}
+
+// etcdStore reads the keys with the etcd v2 API.
+type etcdStore struct {
+	*kvClient
+}
+
+type etcdNode struct {
+	Key   string     `json:"key"`
+	Value string     `json:"value"`
+	Dir   bool       `json:"dir"`
+	Nodes []etcdNode `json:"nodes"`
+}
+
+func (s *etcdStore) list(prefix string, index uint64) (map[string][]byte, uint64, error) {
+	p := "/v2/keys/" + strings.Trim(prefix, "/")
+
+	if index > 0 {
+		// etcd waits until something changed, without a timeout
+		ctx, cancel := context.WithTimeout(context.Background(), kvZonesWait)
+		query := url.Values{"wait": {"true"}, "recursive": {"true"},
+			"waitIndex": {strconv.FormatUint(index+1, 10)}}
+		resp, err := s.getContext(ctx, p, query)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				// like when the index is too old, read it all
+				logPrintf("Watching %s: %s\n", prefix, resp.Status)
+			}
+		}
+		cancel()
+		if err != nil && !isTimeout(err) {
+			return nil, 0, err
+		}
+	}
+
+	resp, err := s.get(p, url.Values{"recursive": {"true"}})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	values := make(map[string][]byte)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		index, err := responseIndex(resp, "X-Etcd-Index")
+		return values, index, err
+	default:
+		return nil, 0, fmt.Errorf("%s: %s", prefix, resp.Status)
+	}
+
+	var r struct {
+		Node etcdNode `json:"node"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, 0, err
+	}
+	var add func(node etcdNode)
+	add = func(node etcdNode) {
+		if !node.Dir {
+			values[node.Key] = []byte(node.Value)
+		}
+		for _, n := range node.Nodes {
+			add(n)
+		}
+	}
+	add(r.Node)
+
+	index, err = responseIndex(resp, "X-Etcd-Index")
+	return values, index, err
+}
+
+// consulStore reads the keys with the Consul KV API.
+type consulStore struct {
+	*kvClient
+}
+
+func (s *consulStore) list(prefix string, index uint64) (map[string][]byte, uint64, error) {
+	query := url.Values{"recurse": {"true"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(kvZonesWait/time.Second)))
+	}
+	resp, err := s.get("/v1/kv/"+strings.TrimPrefix(prefix, "/"), query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	values := make(map[string][]byte)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		index, err := responseIndex(resp, "X-Consul-Index")
+		return values, index, err
+	default:
+		return nil, 0, fmt.Errorf("%s: %s", prefix, resp.Status)
+	}
+
+	var pairs []struct {
+		Key   string
+		Value []byte
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, 0, err
+	}
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, "/") {
+			continue
+		}
+		values[pair.Key] = pair.Value
+	}
+
+	index, err = responseIndex(resp, "X-Consul-Index")
+	return values, index, err
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	. "gopkg.in/check.v1"
+)
+
+type KVZonesSuite struct {
+}
+
+var _ = Suite(&KVZonesSuite{})
+
+// fakeKV is a key-value store with the etcd v2 (/v2/keys/) and Consul
+// (/v1/kv/) APIs, enough for the zone source.
+type fakeKV struct {
+	sync.Mutex
+	values  map[string]string
+	index   uint64
+	changed chan bool
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{values: make(map[string]string), index: 1, changed: make(chan bool)}
+}
+
+func (kv *fakeKV) set(key, value string) {
+	kv.Lock()
+	defer kv.Unlock()
+	if len(value) == 0 {
+		delete(kv.values, key)
+	} else {
+		kv.values[key] = value
+	}
+	kv.index++
+	close(kv.changed)
+	kv.changed = make(chan bool)
+}
+
+// wait waits until the index is more than index (or a while).
+func (kv *fakeKV) wait(index uint64) {
+	kv.Lock()
+	for kv.index <= index {
+		changed := kv.changed
+		kv.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			return
+		}
+		kv.Lock()
+	}
+	kv.Unlock()
+}
+
+func (kv *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v2/keys/"):
+		if query.Get("wait") == "true" {
+			index, _ := strconv.ParseUint(query.Get("waitIndex"), 10, 64)
+			kv.wait(index - 1)
+			json.NewEncoder(w).Encode(map[string]string{"action": "set"})
+			return
+		}
+		kv.serveEtcd(w, strings.TrimPrefix(r.URL.Path, "/v2/keys/")+"/")
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		if index, err := strconv.ParseUint(query.Get("index"), 10, 64); err == nil {
+			kv.wait(index)
+		}
+		kv.serveConsul(w, strings.TrimPrefix(r.URL.Path, "/v1/kv/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// keys returns the keys under the prefix.
+func (kv *fakeKV) keys(prefix string) []string {
+	var keys []string
+	for key := range kv.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (kv *fakeKV) serveEtcd(w http.ResponseWriter, prefix string) {
+	kv.Lock()
+	defer kv.Unlock()
+	w.Header().Set("X-Etcd-Index", strconv.FormatUint(kv.index, 10))
+
+	keys := kv.keys(prefix)
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var node func(dir string) etcdNode
+	node = func(dir string) etcdNode {
+		n := etcdNode{Key: "/" + strings.TrimSuffix(dir, "/"), Dir: true}
+		subdirs := make(map[string]bool)
+		for _, key := range kv.keys(dir) {
+			name := strings.TrimPrefix(key, dir)
+			if i := strings.Index(name, "/"); i >= 0 {
+				if sub := dir + name[:i+1]; !subdirs[sub] {
+					subdirs[sub] = true
+					n.Nodes = append(n.Nodes, node(sub))
+				}
+				continue
+			}
+			n.Nodes = append(n.Nodes, etcdNode{Key: "/" + key, Value: kv.values[key]})
+		}
+		return n
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"action": "get", "node": node(prefix)})
+}
+
+func (kv *fakeKV) serveConsul(w http.ResponseWriter, prefix string) {
+	kv.Lock()
+	defer kv.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(kv.index, 10))
+
+	keys := kv.keys(prefix)
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	type pair struct {
+		Key   string
+		Value []byte
+	}
+	pairs := []pair{{Key: prefix}}
+	for _, key := range keys {
+		pairs = append(pairs, pair{key, []byte(kv.values[key])})
+	}
+	json.NewEncoder(w).Encode(pairs)
+}
+
+func (s *KVZonesSuite) TestKVZones(c *C) {
+	for _, storeType := range []string{"etcd", "consul"} {
+		c.Log(storeType)
+		kv := newFakeKV()
+		ts := httptest.NewServer(kv)
+
+		zoneA := `{ "data": { "": { "ns": [ "ns1.example.net." ] }, "www": { "a": [ "%s" ] } } }`
+		kv.set("geodns/zones/a.example.com.json", strings.Replace(zoneA, "%s", "192.0.2.1", 1))
+		kv.set("geodns/zones/sub/b.example.com.yaml", "data:\n  \"\":\n    ns: [ ns1.example.net. ]\n")
+		kv.set("geodns/zones/README", "not a zone")
+		kv.set("geodns/other/c.example.com.json", `{ "data": {} }`)
+
+		srv := &Server{}
+		// the first server can't be reached
+		srv.SetKVZones(KVZonesConfig{Type: storeType, URL: []string{"http://127.0.0.1:1", ts.URL}, Prefix: "/geodns/zones/"})
+		source := srv.kvZones
+		c.Assert(source, NotNil)
+
+		updates := make(chan zoneUpdate, 10)
+		readUpdates := func() map[string]*Zone {
+			zones := make(map[string]*Zone)
+			for len(updates) > 0 {
+				u := <-updates
+				zones[u.name] = u.zone
+			}
+			return zones
+		}
+
+		values, index, err := source.store.list(source.prefix, 0)
+		c.Assert(err, IsNil)
+		c.Check(values, HasLen, 3)
+		source.update(values, updates)
+		zones := readUpdates()
+		c.Assert(zones, HasLen, 2)
+		c.Assert(zones["a.example.com"], NotNil)
+		c.Check(zones["a.example.com"].Labels["www"].Records[dns.TypeA], HasLen, 1)
+		c.Check(zones["b.example.com"], NotNil)
+
+		// a change is read right away
+		done := make(chan uint64)
+		go func() {
+			values, next, err := source.store.list(source.prefix, index)
+			c.Check(err, IsNil)
+			source.update(values, updates)
+			done <- next
+		}()
+		select {
+		case <-done:
+			c.Fatal("the list didn't wait for a change")
+		case <-time.After(100 * time.Millisecond):
+		}
+		kv.set("geodns/zones/a.example.com.json", strings.Replace(zoneA, "%s", "192.0.2.2", 1))
+		select {
+		case next := <-done:
+			c.Check(next > index, Equals, true)
+		case <-time.After(2 * time.Second):
+			c.Fatal("the change wasn't read")
+		}
+		zones = readUpdates()
+		c.Assert(zones, HasLen, 1)
+		c.Check(zones["a.example.com"].Labels["www"].Records[dns.TypeA][0].RR.(*dns.A).A.String(), Equals, "192.0.2.2")
+
+		update := func() map[string]*Zone {
+			values, _, err := source.store.list(source.prefix, 0)
+			c.Assert(err, IsNil)
+			source.update(values, updates)
+			return readUpdates()
+		}
+
+		// a document that can't be read isn't used (and not read again)
+		kv.set("geodns/zones/a.example.com.json", `{ "data": { "www": { "a": [ "bad" ] } } }`)
+		c.Check(update(), HasLen, 0)
+		c.Check(strings.TrimPrefix(source.keys["a.example.com"], "/"), Equals, "geodns/zones/a.example.com.json")
+		c.Check(update(), HasLen, 0)
+
+		// removed
+		kv.set("geodns/zones/sub/b.example.com.yaml", "")
+		zones = update()
+		c.Assert(zones, HasLen, 1)
+		zone, ok := zones["b.example.com"]
+		c.Check(ok, Equals, true)
+		c.Check(zone, IsNil)
+
+		kv.set("geodns/zones/a.example.com.json", "")
+		kv.set("geodns/zones/README", "")
+		zones = update()
+		c.Assert(zones, HasLen, 1)
+		c.Check(zones["a.example.com"], IsNil)
+		c.Check(source.values, HasLen, 0)
+
+		if storeType == "etcd" {
+			// the wait ends after kvZonesWait without a change
+			_, current, err := source.store.list(source.prefix, 0)
+			c.Assert(err, IsNil)
+			wait := kvZonesWait
+			kvZonesWait = 100 * time.Millisecond
+			start := time.Now()
+			_, next, err := source.store.list(source.prefix, current)
+			kvZonesWait = wait
+			c.Check(err, IsNil)
+			c.Check(next, Equals, current)
+			c.Check(time.Since(start) < 2*time.Second, Equals, true)
+			// the fake store is still waiting
+			kv.set("geodns/other/wake", "up")
+		}
+
+		ts.Close()
+	}
+}
+
+func (s *KVZonesSuite) TestZoneSources(c *C) {
+	srv := &Server{}
+	srv.SetKVZones(KVZonesConfig{Type: "zookeeper", URL: []string{"http://127.0.0.1:2181"}})
+	c.Check(srv.kvZones, IsNil)
+	c.Check(srv.zoneSources("dns"), HasLen, 1)
+
+	srv.SetKVZones(KVZonesConfig{Type: "Consul", URL: []string{"http://127.0.0.1:8500"}, Prefix: "geodns/"})
+	srv.SetHTTPZones(HTTPZonesConfig{URL: []string{"http://127.0.0.1/index.json"}})
+	sources := srv.zoneSources("dns")
+	c.Assert(sources, HasLen, 3)
+	c.Check(sources[0], Equals, srv.dirZones)
+	c.Check(srv.dirZones.dir, Equals, "dns")
+	c.Check(sources[2], Equals, srv.kvZones)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// readMasterFile reads an RFC 1035 master file and returns the records as
+// zone data in the same form as the "data" section of a JSON zone file, so
+// they go through the same setup (and validation) as the JSON records. The
+// options (serial, contact and the other SOA fields) are taken from the SOA
+// record.
+//
+// Each label gets the lowest TTL of its records and records with a higher
+// TTL get their own TTL (except NS records, which can't have one).
+func readMasterFile(zoneName, fileName string) (data, options map[string]interface{}, err error) {
+	rrs, err := readMasterRecords(zoneName, fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return masterRecords(zoneName, fileName, rrs)
+}
+
+// readMasterRecords returns the records in a master file.
+func readMasterRecords(zoneName, fileName string) ([]dns.RR, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var rrs []dns.RR
+	for token := range dns.ParseZone(fh, dns.Fqdn(strings.ToLower(zoneName)), fileName) {
+		if token.Error != nil {
+			return nil, token.Error
+		}
+		rrs = append(rrs, token.RR)
+	}
+	return rrs, nil
+}
+
+// masterRecords returns the zone data and options for the records of a
+// master file or a zone transfer (from source).
+func masterRecords(zoneName, source string, rrs []dns.RR) (data, options map[string]interface{}, err error) {
+	origin := dns.Fqdn(strings.ToLower(zoneName))
+
+	data = make(map[string]interface{})
+	options = make(map[string]interface{})
+
+	// the records with their TTLs, the TTLs are removed again from the
+	// records with the label TTL
+	type masterRecord struct {
+		label map[string]interface{}
+		key   string
+		index int
+		ttl   float64
+	}
+	var records []masterRecord
+
+	// the number of records of each type that isn't supported
+	unsupported := make(map[string]int)
+
+	for _, rr := range rrs {
+		h := rr.Header()
+
+		name := strings.ToLower(h.Name)
+		var labelName string
+		switch {
+		case name == origin:
+			labelName = ""
+		case strings.HasSuffix(name, "."+origin):
+			labelName = strings.TrimSuffix(name, "."+origin)
+		default:
+			log.Printf("Ignoring %s record for '%s' outside of %s in %s\n",
+				dns.TypeToString[h.Rrtype], h.Name, zoneName, source)
+			continue
+		}
+
+		if soa, ok := rr.(*dns.SOA); ok {
+			if labelName != "" {
+				return nil, nil, fmt.Errorf("SOA record for '%s' isn't at the zone apex", h.Name)
+			}
+			options["serial"] = float64(soa.Serial)
+			options["contact"] = soa.Mbox
+			options["primary_ns"] = soa.Ns
+			options["refresh"] = float64(soa.Refresh)
+			options["retry"] = float64(soa.Retry)
+			options["expire"] = float64(soa.Expire)
+			options["minimum"] = float64(soa.Minttl)
+			options["soa_ttl"] = float64(h.Ttl)
+			continue
+		}
+
+		key, value, ok := masterRecordData(rr)
+		if unknown, isUnknown := rr.(*dns.RFC3597); isUnknown && (h.Rrtype == TypeSVCB || h.Rrtype == TypeHTTPS) {
+			// the dns library only reads these in the RFC 3597 form
+			key = "svcb"
+			if h.Rrtype == TypeHTTPS {
+				key = "https"
+			}
+			value, err = svcbData(unknown)
+			if err != nil {
+				return nil, nil, fmt.Errorf("bad %s record for '%s': %s", strings.ToUpper(key), h.Name, err)
+			}
+			ok = true
+		}
+		if !ok {
+			unsupported[dns.TypeToString[h.Rrtype]]++
+			continue
+		}
+
+		label, ok := data[labelName].(map[string]interface{})
+		if !ok {
+			label = make(map[string]interface{})
+			data[labelName] = label
+		}
+
+		list, _ := label[key].([]interface{})
+		label[key] = append(list, value)
+		records = append(records, masterRecord{label, key, len(list), float64(h.Ttl)})
+
+		if ttl, ok := label["ttl"].(float64); !ok || float64(h.Ttl) < ttl {
+			label["ttl"] = float64(h.Ttl)
+		}
+	}
+
+	if len(unsupported) > 0 {
+		types := make([]string, 0, len(unsupported))
+		for t, n := range unsupported {
+			types = append(types, fmt.Sprintf("%s (%d)", t, n))
+		}
+		sort.Strings(types)
+		log.Printf("Skipped the records of unsupported types in %s: %s\n", source, strings.Join(types, ", "))
+	}
+
+	for _, r := range records {
+		if r.ttl == r.label["ttl"] {
+			continue
+		}
+		list := r.label[r.key].([]interface{})
+		switch value := list[r.index].(type) {
+		case []interface{}:
+			// [ "value", weight, ttl ]
+			list[r.index] = []interface{}{value[0], float64(0), r.ttl}
+		case map[string]interface{}:
+			value["ttl"] = r.ttl
+		}
+	}
+
+	return data, options, nil
+}
+
+// masterRecordData returns the zone data key and value for rr.
+func masterRecordData(rr dns.RR) (string, interface{}, bool) {
+	switch rr := rr.(type) {
+	case *dns.A:
+		return "a", []interface{}{rr.A.String()}, true
+	case *dns.AAAA:
+		return "aaaa", []interface{}{rr.AAAA.String()}, true
+	case *dns.PTR:
+		return "ptr", []interface{}{rr.Ptr}, true
+	case *dns.CNAME:
+		return "cname", []interface{}{rr.Target}, true
+	case *dns.NS:
+		return "ns", rr.Ns, true
+	case *dns.MX:
+		return "mx", map[string]interface{}{
+			"mx":         rr.Mx,
+			"preference": float64(rr.Preference),
+		}, true
+	case *dns.TXT:
+		return "txt", map[string]interface{}{"txt": stringsToData(rr.Txt)}, true
+	case *dns.SPF:
+		return "spf", map[string]interface{}{"spf": stringsToData(rr.Txt)}, true
+	case *dns.SRV:
+		return "srv", map[string]interface{}{
+			"priority":   float64(rr.Priority),
+			"srv_weight": float64(rr.Weight),
+			"port":       float64(rr.Port),
+			"target":     rr.Target,
+		}, true
+	case *dns.NAPTR:
+		return "naptr", map[string]interface{}{
+			"order":       float64(rr.Order),
+			"preference":  float64(rr.Preference),
+			"flags":       rr.Flags,
+			"service":     rr.Service,
+			"regexp":      rr.Regexp,
+			"replacement": rr.Replacement,
+		}, true
+	case *dns.URI:
+		return "uri", map[string]interface{}{
+			"priority":   float64(rr.Priority),
+			"uri_weight": float64(rr.Weight),
+			"target":     rr.Target,
+		}, true
+	case *dns.CAA:
+		return "caa", map[string]interface{}{
+			"flag":  float64(rr.Flag),
+			"tag":   rr.Tag,
+			"value": rr.Value,
+		}, true
+	case *dns.TLSA:
+		return "tlsa", map[string]interface{}{
+			"usage":         float64(rr.Usage),
+			"selector":      float64(rr.Selector),
+			"matching_type": float64(rr.MatchingType),
+			"certificate":   rr.Certificate,
+		}, true
+	case *dns.SSHFP:
+		return "sshfp", map[string]interface{}{
+			"algorithm":   float64(rr.Algorithm),
+			"type":        float64(rr.Type),
+			"fingerprint": rr.FingerPrint,
+		}, true
+	}
+	return "", nil, false
+}
+
+func stringsToData(strs []string) []interface{} {
+	list := make([]interface{}, len(strs))
+	for i, s := range strs {
+		list[i] = s
+	}
+	return list
+}
+
+// mergeZoneData adds the labels and record types from the master file data
+// that aren't in the JSON zone data. Record types set in the JSON file
+// replace the records of that type from the master file, and labels in
+// both use the TTL from the JSON file.
+func mergeZoneData(data, master map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	for labelName, v := range master {
+		masterLabel := v.(map[string]interface{})
+		label, ok := data[labelName].(map[string]interface{})
+		if !ok {
+			data[labelName] = masterLabel
+			continue
+		}
+		for key, records := range masterLabel {
+			// the label TTL comes from the JSON file (or the zone default)
+			if key == "ttl" {
+				continue
+			}
+			if _, ok := label[key]; !ok {
+				label[key] = records
+			}
+		}
+	}
+	return data
+}
@@ -1,6 +1,6 @@
 package main
 
-//go:generate esc -o templates.go templates/
+//go:generate esc -o templates.go templates/ zone.schema.json
 
 import (
 	"encoding/json"
@@ -30,6 +30,9 @@ func (s *MonitorSuite) SetUpSuite(c *C) {
 	// https://groups.google.com/forum/?fromgroups=#!topic/golang-nuts/Jk785WB7F8I
 
 	srv := Server{}
+	srv.SetResolver(testResolver())
+	// the zone handlers are shared with the serve tests
+	srv.SetTsigSecrets(testTsigSecrets)
 	srv.zonesReadDir("dns", s.zones)
 	go httpHandler(s.zones)
 	time.Sleep(500 * time.Millisecond)
@@ -11,6 +11,10 @@ func (label *Label) Picker(qtype uint16, max int) Records {
 	if qtype == dns.TypeANY {
 		var result []Record
 		for rtype := range label.Records {
+			if rtype == dns.TypeMF || rtype == dns.TypeMD {
+				// aliases and ANAMEs are only used internally
+				continue
+			}
 
 			rtypeRecords := label.Picker(rtype, max)
 
@@ -28,10 +32,10 @@ func (label *Label) Picker(qtype uint16, max int) Records {
 
 		// not "balanced", just return all
 		if label.Weight[qtype] == 0 {
-			return labelRR
+			return sameTtl(labelRR)
 		}
 
-		if qtype == dns.TypeCNAME || qtype == dns.TypeMF {
+		if qtype == dns.TypeCNAME || qtype == dns.TypeMF || qtype == dns.TypeMD {
 			max = 1
 		}
 
@@ -62,7 +66,38 @@ func (label *Label) Picker(qtype uint16, max int) Records {
 			}
 		}
 
-		return result
+		return sameTtl(result)
 	}
 	return nil
 }
+
+// sameTtl returns the records with the lowest of their TTLs, as all the
+// records in an RRset must have the same TTL (RFC 2181, section 5.2).
+// Records with a different TTL are copied rather than changed.
+func sameTtl(records Records) Records {
+	if len(records) < 2 {
+		return records
+	}
+	ttl := records[0].RR.Header().Ttl
+	mixed := false
+	for _, r := range records[1:] {
+		if t := r.RR.Header().Ttl; t != ttl {
+			mixed = true
+			if t < ttl {
+				ttl = t
+			}
+		}
+	}
+	if !mixed {
+		return records
+	}
+	result := make(Records, len(records))
+	for i, r := range records {
+		if r.RR.Header().Ttl != ttl {
+			r.RR = dns.Copy(r.RR)
+			r.RR.Header().Ttl = ttl
+		}
+		result[i] = r
+	}
+	return result
+}
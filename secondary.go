@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Secondary zones are transferred (with AXFR and then IXFR) from another DNS
+// server, the primary, rather than read from the zone directory. They are
+// configured in the configuration file:
+//
+//	[secondary "example.org"]
+//	primary = 192.0.2.53
+//	tsig = transfer-key.
+//	refresh = 300
+//	notify = true
+//	file = /var/lib/geodns/example.org.zone
+//
+// The serial is checked every "refresh" seconds (the SOA refresh time by
+// default) and right away when a primary sends a NOTIFY (with "notify"); the
+// zone is transferred when the serial on the primary is newer.
+// The records are set up like the records of a master file and the last
+// good copy of the zone is kept in "file" (if set), so it can be served
+// before the primary answers after a restart. If the primaries can't be
+// reached for the SOA expire time the zone isn't served anymore.
+
+// secondaryRetry is how long to wait before trying again when the zone
+// couldn't be transferred and there's no SOA retry time yet
+const secondaryRetry = 30 * time.Second
+
+// secondaryMinInterval is the shortest time between transfers
+const secondaryMinInterval = 5 * time.Second
+
+type secondaryZone struct {
+	name      string
+	primaries []string
+	key       string
+	refresh   time.Duration
+	notify    bool
+	file      string
+
+	// notified gets a value when a primary sent a NOTIFY; the NOTIFY has
+	// to come from one of primaryIPs (host names are resolved when the
+	// configuration is loaded)
+	notified   chan bool
+	primaryIPs []net.IP
+
+	srv *Server
+
+	// the current records (SOA record first) and when the serial was
+	// last checked
+	records []dns.RR
+	checked time.Time
+}
+
+// SetSecondaries sets up the secondary zones. The zones are transferred
+// when the zonesReader is started.
+func (srv *Server) SetSecondaries(configs map[string]SecondaryConfig) {
+	srv.secondaries = make(map[string]*secondaryZone, len(configs))
+	for name, conf := range configs {
+		s := &secondaryZone{
+			name:     name,
+			refresh:  time.Duration(conf.Refresh) * time.Second,
+			notify:   conf.Notify,
+			file:     conf.File,
+			notified: make(chan bool, 1),
+			srv:      srv,
+		}
+		if len(conf.TSIG) > 0 {
+			s.key = dns.Fqdn(strings.ToLower(conf.TSIG))
+			if _, ok := srv.tsigSecrets[s.key]; !ok {
+				log.Printf("Secondary zone %s: unknown TSIG key '%s'", name, conf.TSIG)
+				continue
+			}
+		}
+		for _, primary := range conf.Primary {
+			addr, err := dnsAddr(strings.TrimSpace(primary))
+			if err != nil {
+				log.Printf("Secondary zone %s: %s", name, err)
+				continue
+			}
+			s.primaries = append(s.primaries, addr)
+		}
+		if len(s.primaries) == 0 {
+			log.Printf("Secondary zone %s has no primary servers", name)
+			continue
+		}
+		s.primaryIPs = lookupPrimaries(name, s.primaries)
+		srv.secondaries[name] = s
+	}
+}
+
+func (srv *Server) isSecondary(zoneName string) bool {
+	_, ok := srv.secondaries[zoneName]
+	return ok
+}
+
+func (s *secondaryZone) run(updates chan<- zoneUpdate) {
+	if zone := s.readFile(); zone != nil {
+		updates <- zoneUpdate{name: s.name, zone: zone}
+	}
+	for {
+		wait := s.update(updates)
+		if wait < secondaryMinInterval {
+			wait = secondaryMinInterval
+		}
+		select {
+		case <-time.After(wait):
+		case <-s.notified:
+		}
+	}
+}
+
+// readFile reads the last copy of the zone.
+func (s *secondaryZone) readFile() *Zone {
+	if len(s.file) == 0 {
+		return nil
+	}
+	fileInfo, err := os.Stat(s.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Could not read secondary zone %s: %s", s.name, err)
+		}
+		return nil
+	}
+	rrs, err := readMasterRecords(s.name, s.file)
+	if err == nil && (len(rrs) == 0 || rrs[0].Header().Rrtype != dns.TypeSOA) {
+		err = fmt.Errorf("no SOA record")
+	}
+	if err != nil {
+		log.Printf("Could not read secondary zone %s from %s: %s", s.name, s.file, err)
+		return nil
+	}
+	zone, err := readZoneRecords(s.name, s.file, rrs)
+	if err != nil {
+		log.Printf("Error reading zone '%s': %s", s.name, err)
+		return nil
+	}
+	s.records = rrs
+	// the file is touched whenever the serial is checked
+	s.checked = fileInfo.ModTime()
+	return zone
+}
+
+// writeFile saves the current copy of the zone.
+func (s *secondaryZone) writeFile() {
+	if len(s.file) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "; %s, transferred %s\n", s.name, time.Now().UTC().Format(time.RFC3339))
+	for _, rr := range s.records {
+		buf.WriteString(rr.String())
+		buf.WriteByte('\n')
+	}
+	if err := writeFileAtomic(s.file, buf.Bytes()); err != nil {
+		log.Printf("Could not save secondary zone %s to %s: %s", s.name, s.file, err)
+	}
+}
+
+func (s *secondaryZone) soa() *dns.SOA {
+	if len(s.records) == 0 {
+		return nil
+	}
+	soa, _ := s.records[0].(*dns.SOA)
+	return soa
+}
+
+// update checks the serial on the primaries and transfers the zone if it
+// changed. It returns how long to wait before the next check.
+func (s *secondaryZone) update(updates chan<- zoneUpdate) time.Duration {
+	soa := s.soa()
+
+	rrs, err := s.transfer()
+	if err != nil {
+		log.Printf("[zone %s] transfer failed: %s", s.name, err)
+		if soa == nil {
+			return secondaryRetry
+		}
+		if time.Since(s.checked) > time.Duration(soa.Expire)*time.Second {
+			log.Printf("[zone %s] expired, the serial couldn't be checked for %d seconds", s.name, soa.Expire)
+			s.records = nil
+			updates <- zoneUpdate{name: s.name}
+			return secondaryRetry
+		}
+		return time.Duration(soa.Retry) * time.Second
+	}
+
+	s.checked = time.Now()
+	if rrs == nil {
+		// up to date
+		if len(s.file) > 0 {
+			os.Chtimes(s.file, s.checked, s.checked)
+		}
+		return s.refreshInterval()
+	}
+
+	var zone *Zone
+	err = checkUnsigned(rrs)
+	if err == nil {
+		zone, err = readZoneRecords(s.name, "the transfer of "+s.name, rrs)
+	}
+	if err != nil {
+		log.Printf("Error reading zone '%s': %s", s.name, err)
+		if soa == nil {
+			return secondaryRetry
+		}
+		return time.Duration(soa.Retry) * time.Second
+	}
+
+	log.Printf("[zone %s] transferred serial %d", s.name, rrs[0].(*dns.SOA).Serial)
+	s.records = rrs
+	s.writeFile()
+	updates <- zoneUpdate{name: s.name, zone: zone}
+	return s.refreshInterval()
+}
+
+func (s *secondaryZone) refreshInterval() time.Duration {
+	if s.refresh > 0 {
+		return s.refresh
+	}
+	return time.Duration(s.soa().Refresh) * time.Second
+}
+
+// transfer gets the zone from the first primary that answers, if the
+// serial on the primary is newer (RFC 1034 section 4.3.5). It returns nil if
+// the zone is up to date.
+func (s *secondaryZone) transfer() ([]dns.RR, error) {
+	var err error
+	for _, primary := range s.primaries {
+		if soa := s.soa(); soa != nil {
+			var serial uint32
+			serial, err = s.primarySerial(primary)
+			if err != nil {
+				log.Printf("[zone %s] SOA query to %s failed: %s", s.name, primary, err)
+				continue
+			}
+			if !serialNewer(serial, soa.Serial) {
+				return nil, nil
+			}
+		}
+
+		var rrs []dns.RR
+		rrs, err = s.transferFrom(primary, s.soa() != nil)
+		if err != nil && s.soa() != nil {
+			// maybe the primary doesn't do IXFR
+			logPrintf("[zone %s] IXFR from %s failed: %s\n", s.name, primary, err)
+			rrs, err = s.transferFrom(primary, false)
+		}
+		if err == nil {
+			return rrs, nil
+		}
+		log.Printf("[zone %s] transfer from %s failed: %s", s.name, primary, err)
+	}
+	return nil, err
+}
+
+// primarySerial returns the serial of the zone on the primary.
+func (s *secondaryZone) primarySerial(primary string) (uint32, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(s.name), dns.TypeSOA)
+
+	c := new(dns.Client)
+	if len(s.key) > 0 {
+		c.TsigSecret = s.srv.tsigSecrets
+		m.SetTsig(s.key, s.srv.tsigAlgorithm(s.key), 300, time.Now().Unix())
+	}
+	r, _, err := c.Exchange(m, primary)
+	if err != nil {
+		return 0, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return 0, fmt.Errorf("%s", dns.RcodeToString[r.Rcode])
+	}
+	for _, rr := range r.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+	return 0, fmt.Errorf("no SOA record")
+}
+
+// serialNewer returns true if serial a is newer than b, with serial number
+// arithmetic (RFC 1982).
+func serialNewer(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// transferFrom transfers the zone from the primary, with IXFR if ixfr is
+// set. It returns the new records or nil if the zone is up to date.
+func (s *secondaryZone) transferFrom(primary string, ixfr bool) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	origin := dns.Fqdn(s.name)
+	if ixfr {
+		soa := s.soa()
+		m.SetIxfr(origin, soa.Serial, soa.Ns, soa.Mbox)
+	} else {
+		m.SetAxfr(origin)
+	}
+
+	t := new(dns.Transfer)
+	if len(s.key) > 0 {
+		t.TsigSecret = s.srv.tsigSecrets
+		m.SetTsig(s.key, s.srv.tsigAlgorithm(s.key), 300, time.Now().Unix())
+	}
+
+	env, err := t.In(m, primary)
+	if err != nil {
+		return nil, err
+	}
+	var rrs []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			err = e.Error
+			continue
+		}
+		rrs = append(rrs, e.RR...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return applyTransfer(s.records, rrs)
+}
+
+// applyTransfer returns the records of the zone after the transfer (rrs)
+// from the current records: the records of an AXFR (or an IXFR answered
+// with the whole zone) or the current records with the changes of an IXFR
+// applied. It returns nil if the zone is up to date.
+func applyTransfer(current, rrs []dns.RR) ([]dns.RR, error) {
+	if len(rrs) == 0 {
+		return nil, fmt.Errorf("no records")
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, fmt.Errorf("no SOA record")
+	}
+
+	var currentSOA *dns.SOA
+	if len(current) > 0 {
+		currentSOA, _ = current[0].(*dns.SOA)
+	}
+	if currentSOA != nil && currentSOA.Serial == soa.Serial {
+		return nil, nil
+	}
+	if len(rrs) < 2 {
+		return nil, fmt.Errorf("incomplete transfer")
+	}
+	if last, ok := rrs[len(rrs)-1].(*dns.SOA); !ok || last.Serial != soa.Serial {
+		return nil, fmt.Errorf("incomplete transfer")
+	}
+
+	// an IXFR with the changes starts with the SOA of the current version
+	if from, ok := rrs[1].(*dns.SOA); !ok || currentSOA == nil || from.Serial != currentSOA.Serial {
+		return rrs[:len(rrs)-1], nil
+	}
+
+	records := make([]dns.RR, 0, len(current))
+	records = append(records, current[1:]...)
+	serial := currentSOA.Serial
+
+	// the differences: the SOA of the old version, the deleted records,
+	// the SOA of the new version and the added records
+	for i := 1; i < len(rrs)-1; {
+		from := rrs[i].(*dns.SOA)
+		if from.Serial != serial {
+			return nil, fmt.Errorf("IXFR from serial %d, not %d", from.Serial, serial)
+		}
+		for i++; i < len(rrs)-1 && rrs[i].Header().Rrtype != dns.TypeSOA; i++ {
+			records = deleteRecord(records, rrs[i])
+		}
+		if i == len(rrs)-1 {
+			return nil, fmt.Errorf("incomplete IXFR")
+		}
+		serial = rrs[i].(*dns.SOA).Serial
+		for i++; i < len(rrs)-1 && rrs[i].Header().Rrtype != dns.TypeSOA; i++ {
+			records = append(records, rrs[i])
+		}
+	}
+	if serial != soa.Serial {
+		return nil, fmt.Errorf("IXFR to serial %d, not %d", serial, soa.Serial)
+	}
+
+	return append([]dns.RR{soa}, records...), nil
+}
+
+// checkUnsigned returns an error for a signed zone: the DNSSEC records can't
+// be served, and the zone without them would fail validation.
+func checkUnsigned(rrs []dns.RR) error {
+	for _, rr := range rrs {
+		switch rr.Header().Rrtype {
+		case dns.TypeDNSKEY, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			return fmt.Errorf("the zone is signed (%s record for '%s'), DNSSEC isn't supported",
+				dns.TypeToString[rr.Header().Rrtype], rr.Header().Name)
+		}
+	}
+	return nil
+}
+
+// deleteRecord deletes the record from records (the TTL doesn't have to
+// match).
+func deleteRecord(records []dns.RR, rr dns.RR) []dns.RR {
+	key := recordKey(rr)
+	for i, r := range records {
+		if recordKey(r) == key {
+			return append(records[:i], records[i+1:]...)
+		}
+	}
+	return records
+}
+
+func recordKey(rr dns.RR) string {
+	rr = dns.Copy(rr)
+	rr.Header().Ttl = 0
+	return strings.ToLower(rr.String())
+}
+
+// serveNotify answers a NOTIFY for a secondary zone; when it's from one of
+// the primaries (and signed with the TSIG key of the zone, if it has one) the
+// serial is checked right away.
+func (srv *Server) serveNotify(w dns.ResponseWriter, req *dns.Msg, z *Zone, ip net.IP) {
+	s, ok := srv.secondaries[strings.ToLower(strings.TrimSuffix(z.Origin, "."))]
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Opcode = dns.OpcodeNotify
+	m.Authoritative = true
+
+	if !ok || !s.notify || !s.isPrimary(ip) {
+		log.Printf("[zone %s] refused NOTIFY from %s", z.Origin, w.RemoteAddr())
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+
+	if len(s.key) > 0 {
+		tsig := req.IsTsig()
+		if tsig == nil || strings.ToLower(tsig.Hdr.Name) != s.key || w.TsigStatus() != nil {
+			log.Printf("[zone %s] refused NOTIFY from %s without a valid %s signature", z.Origin, w.RemoteAddr(), s.key)
+			m.Rcode = dns.RcodeNotAuth
+			w.WriteMsg(m)
+			return
+		}
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	}
+
+	logPrintf("[zone %s] NOTIFY from %s\n", z.Origin, w.RemoteAddr())
+	select {
+	case s.notified <- true:
+	default:
+		// a check is pending already
+	}
+	w.WriteMsg(m)
+}
+
+// isPrimary returns true if ip is one of the primaries of the zone.
+func (s *secondaryZone) isPrimary(ip net.IP) bool {
+	for _, primaryIP := range s.primaryIPs {
+		if primaryIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupPrimaries returns the addresses of the primaries (host:port).
+func lookupPrimaries(zoneName string, primaries []string) []net.IP {
+	var ips []net.IP
+	for _, primary := range primaries {
+		host, _, err := net.SplitHostPort(primary)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+			continue
+		}
+		hostIPs, err := net.LookupIP(host)
+		if err != nil {
+			log.Printf("Secondary zone %s: could not look up primary %s: %s", zoneName, host, err)
+			continue
+		}
+		ips = append(ips, hostIPs...)
+	}
+	return ips
+}
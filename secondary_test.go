@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+	. "gopkg.in/check.v1"
+)
+
+const (
+	PRIMARY = "127.0.0.1:8857"
+)
+
+type SecondarySuite struct {
+	dir string
+	mux *dns.ServeMux
+	srv *Server
+}
+
+var _ = Suite(&SecondarySuite{})
+
+// SetUpSuite starts a primary server for secondary.example.com on a private
+// mux (the zone is set with setPrimaryZone).
+func (s *SecondarySuite) SetUpSuite(c *C) {
+	NewMetrics()
+
+	s.dir = c.MkDir()
+	s.mux = dns.NewServeMux()
+	s.srv = &Server{tsigSecrets: testTsigSecrets}
+
+	for _, p := range []string{"tcp", "udp"} {
+		server := &dns.Server{Addr: ":8857", Net: p, Handler: s.mux, TsigSecret: testTsigSecrets}
+		started := make(chan error, 1)
+		server.NotifyStartedFunc = func() { started <- nil }
+		go func() { started <- server.ListenAndServe() }()
+		c.Assert(<-started, IsNil)
+	}
+}
+
+// setPrimaryZone serves a version of secondary.example.com on the primary.
+func (s *SecondarySuite) setPrimaryZone(c *C, old *Zone, serial int, www ...string) *Zone {
+	fileName := s.dir + "/secondary.example.com.json"
+	data := fmt.Sprintf(`{ "serial": %d, "transfer": { "tsig": "transfer-key." },
+  "data": {
+    "": { "ns": [ "ns1.example.net.", "ns2.example.net." ] },
+    "www": { "a": [ [ "%s", 10 ], [ "%s", 10 ] ] },
+    "mail": { "a": [ "192.0.2.25" ] },
+    "*.users": { "cname": "users.example.net." }
+  }
+}`, serial, www[0], www[1])
+	c.Assert(ioutil.WriteFile(fileName, []byte(data), 0644), IsNil)
+	zone, err := readZoneFile("secondary.example.com", fileName)
+	c.Assert(err, IsNil)
+	zone.SetupMetrics(old)
+	zone.setupTransferHistory(old)
+	s.mux.HandleFunc("secondary.example.com.", s.srv.setupServerFunc(zone))
+	return zone
+}
+
+func (s *SecondarySuite) secondary(file string) *secondaryZone {
+	return &secondaryZone{
+		name:       "secondary.example.com",
+		primaries:  []string{PRIMARY},
+		key:        "transfer-key.",
+		notify:     true,
+		file:       file,
+		notified:   make(chan bool, 1),
+		primaryIPs: lookupPrimaries("secondary.example.com", []string{PRIMARY}),
+		srv:        s.srv,
+	}
+}
+
+func wwwAddrs(zone *Zone) []string {
+	var ips []string
+	for _, r := range zone.Labels["www"].Records[dns.TypeA] {
+		ips = append(ips, r.RR.(*dns.A).A.String())
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+func (s *SecondarySuite) TestTransfers(c *C) {
+	v1 := s.setPrimaryZone(c, nil, 1, "192.0.2.1", "192.0.2.2")
+
+	file := s.dir + "/secondary.example.com.zone"
+	sec := s.secondary(file)
+	updates := make(chan zoneUpdate, 1)
+
+	// AXFR
+	sec.update(updates)
+	c.Assert(updates, HasLen, 1)
+	u := <-updates
+	c.Check(u.name, Equals, "secondary.example.com")
+	c.Assert(u.zone, NotNil)
+	c.Check(u.zone.Options.Serial, Equals, 1)
+	c.Check(wwwAddrs(u.zone), DeepEquals, []string{"192.0.2.1", "192.0.2.2"})
+	c.Check(u.zone.Labels["mail"].Records[dns.TypeA], HasLen, 1)
+	c.Check(u.zone.Labels[""].Records[dns.TypeNS], HasLen, 2)
+	c.Check(u.zone.GlobLabels, HasLen, 1)
+
+	// up to date
+	sec.update(updates)
+	c.Check(updates, HasLen, 0)
+
+	// IXFR
+	v2 := s.setPrimaryZone(c, v1, 2, "192.0.2.1", "192.0.2.3")
+	c.Assert(v2.TransferHistory, HasLen, 1)
+	sec.update(updates)
+	c.Assert(updates, HasLen, 1)
+	u = <-updates
+	c.Check(u.zone.Options.Serial, Equals, 2)
+	c.Check(wwwAddrs(u.zone), DeepEquals, []string{"192.0.2.1", "192.0.2.3"})
+	c.Check(u.zone.Labels["mail"].Records[dns.TypeA], HasLen, 1)
+
+	// the last copy is read after a restart
+	sec = s.secondary(file)
+	zone := sec.readFile()
+	c.Assert(zone, NotNil)
+	c.Check(zone.Options.Serial, Equals, 2)
+	c.Check(wwwAddrs(zone), DeepEquals, []string{"192.0.2.1", "192.0.2.3"})
+
+	// and the changes since then transferred with IXFR
+	s.setPrimaryZone(c, v2, 3, "192.0.2.4", "192.0.2.3")
+	sec.update(updates)
+	c.Assert(updates, HasLen, 1)
+	u = <-updates
+	c.Check(u.zone.Options.Serial, Equals, 3)
+	c.Check(wwwAddrs(u.zone), DeepEquals, []string{"192.0.2.3", "192.0.2.4"})
+
+	// not transferred when the serial on the primary is older
+	v3 := s.setPrimaryZone(c, nil, 2, "192.0.2.5", "192.0.2.6")
+	sec.update(updates)
+	c.Check(updates, HasLen, 0)
+	c.Check(sec.soa().Serial, Equals, uint32(3))
+	s.setPrimaryZone(c, v3, 4, "192.0.2.5", "192.0.2.6")
+	sec.update(updates)
+	c.Assert(updates, HasLen, 1)
+	u = <-updates
+	c.Check(wwwAddrs(u.zone), DeepEquals, []string{"192.0.2.5", "192.0.2.6"})
+
+	// without the TSIG key the primary refuses
+	sec = s.secondary("")
+	sec.key = ""
+	c.Check(sec.update(updates), Equals, secondaryRetry)
+	c.Check(updates, HasLen, 0)
+}
+
+func (s *SecondarySuite) TestExpire(c *C) {
+	s.setPrimaryZone(c, nil, 1, "192.0.2.1", "192.0.2.2")
+
+	sec := s.secondary("")
+	updates := make(chan zoneUpdate, 1)
+	sec.update(updates)
+	c.Assert(updates, HasLen, 1)
+	<-updates
+
+	// the primary can't be reached, but the zone hasn't expired yet
+	sec.primaries = []string{"127.0.0.1:1"}
+	c.Check(sec.update(updates), Equals, 5400*time.Second)
+	c.Check(updates, HasLen, 0)
+
+	sec.checked = time.Now().Add(-1209601 * time.Second)
+	sec.update(updates)
+	c.Assert(updates, HasLen, 1)
+	u := <-updates
+	c.Check(u.zone, IsNil)
+	c.Check(sec.soa(), IsNil)
+}
+
+func (s *SecondarySuite) TestNotify(c *C) {
+	zone := s.setPrimaryZone(c, nil, 1, "192.0.2.1", "192.0.2.2")
+
+	// the zone on "the secondary" (the same mux)
+	sec := s.secondary("")
+	srv := &Server{secondaries: map[string]*secondaryZone{sec.name: sec}}
+	s.mux.HandleFunc("secondary.example.com.", srv.setupServerFunc(zone))
+
+	notifyWith := func(key string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetNotify("secondary.example.com.")
+		client := new(dns.Client)
+		if len(key) > 0 {
+			m.SetTsig(key, dns.HmacSHA256, 300, time.Now().Unix())
+			client.TsigSecret = testTsigSecrets
+		}
+		r, _, err := client.Exchange(m, PRIMARY)
+		c.Assert(err, IsNil)
+		return r
+	}
+	notify := func() *dns.Msg {
+		return notifyWith("transfer-key.")
+	}
+
+	r := notify()
+	c.Check(r.Rcode, Equals, dns.RcodeSuccess)
+	c.Check(r.Opcode, Equals, dns.OpcodeNotify)
+	c.Check(r.IsTsig(), NotNil)
+	c.Check(sec.notified, HasLen, 1)
+	<-sec.notified
+
+	// with a TSIG key for the zone, NOTIFYs have to be signed with it
+	r = notifyWith("")
+	c.Check(r.Rcode, Equals, dns.RcodeNotAuth)
+	c.Check(sec.notified, HasLen, 0)
+	sec.key = ""
+	r = notifyWith("")
+	c.Check(r.Rcode, Equals, dns.RcodeSuccess)
+	c.Check(sec.notified, HasLen, 1)
+	sec.key = "transfer-key."
+
+	// only from the primaries (as resolved when the configuration was
+	// loaded)
+	sec.primaries = []string{"192.0.2.53:53"}
+	<-sec.notified
+	r = notify()
+	c.Check(r.Rcode, Equals, dns.RcodeSuccess)
+	<-sec.notified
+	sec.primaryIPs = lookupPrimaries(sec.name, sec.primaries)
+	r = notify()
+	c.Check(r.Rcode, Equals, dns.RcodeRefused)
+	c.Check(sec.notified, HasLen, 0)
+
+	c.Check(sec.isPrimary(net.ParseIP("192.0.2.53")), Equals, true)
+}
+
+func (s *SecondarySuite) TestApplyTransfer(c *C) {
+	rr := func(s string) dns.RR {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			panic(err)
+		}
+		return rr
+	}
+	soa := func(serial int) dns.RR {
+		return rr(fmt.Sprintf("example.com. 3600 IN SOA ns1.example.net. hostmaster.example.com. %d 5400 5400 1209600 3600", serial))
+	}
+	a1 := rr("www.example.com. 300 IN A 192.0.2.1")
+	a2 := rr("www.example.com. 300 IN A 192.0.2.2")
+	a3 := rr("www.example.com. 600 IN A 192.0.2.3")
+
+	current := []dns.RR{soa(1), a1, a2}
+
+	rrs, err := applyTransfer(current, []dns.RR{soa(1)})
+	c.Check(err, IsNil)
+	c.Check(rrs, IsNil)
+
+	// the whole zone
+	rrs, err = applyTransfer(current, []dns.RR{soa(2), a3, soa(2)})
+	c.Check(err, IsNil)
+	c.Check(rrs, DeepEquals, []dns.RR{soa(2), a3})
+
+	// the changes, the TTL of deleted records doesn't matter
+	rrs, err = applyTransfer(current, []dns.RR{soa(3),
+		soa(1), rr("www.example.com. 60 IN A 192.0.2.1"), soa(2), a3,
+		soa(2), soa(3), a1,
+		soa(3)})
+	c.Check(err, IsNil)
+	c.Check(rrs, DeepEquals, []dns.RR{soa(3), a2, a3, a1})
+
+	_, err = applyTransfer(current, []dns.RR{soa(3), soa(1), soa(3)})
+	c.Check(err, ErrorMatches, "incomplete IXFR")
+	_, err = applyTransfer(current, []dns.RR{soa(3), soa(1), soa(2), a3, soa(3)})
+	c.Check(err, ErrorMatches, "IXFR to serial 2, not 3")
+	_, err = applyTransfer(current, []dns.RR{soa(2), a3})
+	c.Check(err, ErrorMatches, "incomplete transfer")
+
+	c.Check(checkUnsigned(current), IsNil)
+	rrsig := rr("www.example.com. 300 IN RRSIG A 13 3 300 20260101000000 20250101000000 12345 example.com. AAAA")
+	c.Check(checkUnsigned(append(current, rrsig)), ErrorMatches, `the zone is signed \(RRSIG record for 'www.example.com.'\), DNSSEC isn't supported`)
+
+	// serial number arithmetic
+	c.Check(serialNewer(2, 1), Equals, true)
+	c.Check(serialNewer(1, 1), Equals, false)
+	c.Check(serialNewer(1, 2), Equals, false)
+	c.Check(serialNewer(1, 4294967295), Equals, true)
+	_, err = applyTransfer(nil, []dns.RR{a3})
+	c.Check(err, ErrorMatches, "no SOA record")
+}
+
+func (s *SecondarySuite) TestSecondaryZoneFiles(c *C) {
+	srv := &Server{}
+	srv.SetSecondaries(map[string]SecondaryConfig{
+		"test.example.org": {Primary: []string{PRIMARY}},
+		"bad.example.org":  {Primary: []string{"ns:99999"}},
+		"key.example.org":  {Primary: []string{PRIMARY}, TSIG: "unknown-key"},
+	})
+	c.Check(srv.secondaries, HasLen, 1)
+	c.Check(srv.isSecondary("test.example.org"), Equals, true)
+
+	// the zone file for a secondary zone is ignored (and the zone isn't
+	// removed because there's no file)
+	dir := c.MkDir()
+	for _, name := range []string{"primary.example.org", "test.example.org"} {
+		data := `{ "data": { "": { "ns": [ "ns1.example.net." ] } } }`
+		c.Assert(ioutil.WriteFile(dir+"/"+name+".json", []byte(data), 0644), IsNil)
+	}
+	zones := Zones{"test.example.org": NewZone("test.example.org")}
+	c.Assert(srv.zonesReadDir(dir, zones), IsNil)
+	defer dns.HandleRemove("primary.example.org")
+	c.Check(zones["primary.example.org"], NotNil)
+	c.Check(zones["test.example.org"].Labels, HasLen, 0)
+}
@@ -69,6 +69,16 @@ func (srv *Server) serve(w dns.ResponseWriter, req *dns.Msg, z *Zone) {
 
 	z.Metrics.ClientStats.Add(realIP.String())
 
+	if req.Opcode == dns.OpcodeNotify {
+		srv.serveNotify(w, req, z, realIP)
+		return
+	}
+
+	if qtype == dns.TypeAXFR || qtype == dns.TypeIXFR {
+		srv.serveTransfer(w, req, z, realIP, qle)
+		return
+	}
+
 	var ip net.IP // EDNS or real IP
 	var edns *dns.EDNS0_SUBNET
 	var opt_rr *dns.OPT
@@ -138,7 +148,35 @@ func (srv *Server) serve(w dns.ResponseWriter, req *dns.Msg, z *Zone) {
 		}
 	}
 
-	labels, labelQtype := z.findLabels(label, targets, qTypes{dns.TypeMF, dns.TypeCNAME, qtype})
+	if cut, ok := z.FindDelegation(label); ok {
+		// Referral to the nameservers for the delegated name
+		ns, _ := z.findLabels(cut, targets, qTypes{dns.TypeNS})
+		// the whole NS set, not just max_hosts of them
+		for _, record := range ns.Records[dns.TypeNS] {
+			rr := dns.Copy(record.RR)
+			rr.Header().Name = cut + "." + z.Origin + "."
+			m.Ns = append(m.Ns, rr)
+		}
+		if extra := z.AdditionalRecords(m.Ns, targets); len(extra) > 0 {
+			m.Extra = append(extra, m.Extra...)
+		}
+		m.Authoritative = false
+
+		if qle != nil {
+			qle.LabelName = cut
+		}
+
+		w.WriteMsg(m)
+		return
+	}
+
+	labels, labelQtype := z.findLabels(label, targets, answerQTypes(qtype))
+
+	if labels == nil && labelQtype == dns.TypeMF {
+		log.Printf("[zone %s] too many aliases looking up '%s'", z.Origin, label)
+		dns.HandleFailed(w, req)
+		return
+	}
 	if labelQtype == 0 {
 		labelQtype = qtype
 	}
@@ -201,14 +239,21 @@ func (srv *Server) serve(w dns.ResponseWriter, req *dns.Msg, z *Zone) {
 		return
 	}
 
-	if servers := labels.Picker(labelQtype, labels.MaxHosts); servers != nil {
-		var rrs []dns.RR
-		for _, record := range servers {
-			rr := dns.Copy(record.RR)
-			rr.Header().Name = qname
-			rrs = append(rrs, rr)
-		}
-		m.Answer = rrs
+	answer, err := srv.pickRecords(z, labels, labelQtype, qname, qtype, ip)
+	if err != nil {
+		log.Printf("[zone %s] %s", z.Origin, err)
+		dns.HandleFailed(w, req)
+		return
+	}
+	m.Answer = answer
+
+	if labelQtype == dns.TypeCNAME && qtype != dns.TypeCNAME && qtype != dns.TypeANY {
+		m.Answer = append(m.Answer, srv.followCNAMEs(z, label, m.Answer, qtype, targets, ip)...)
+	}
+
+	if extra := z.AdditionalRecords(m.Answer, targets); len(extra) > 0 {
+		// keep the OPT record (if any) last
+		m.Extra = append(extra, m.Extra...)
 	}
 
 	if len(m.Answer) == 0 {
@@ -223,7 +268,7 @@ func (srv *Server) serve(w dns.ResponseWriter, req *dns.Msg, z *Zone) {
 		qle.Answers = len(m.Answer)
 		qle.Rcode = m.Rcode
 	}
-	err := w.WriteMsg(m)
+	err = w.WriteMsg(m)
 	if err != nil {
 		// if Pack'ing fails the Write fails. Return SERVFAIL.
 		log.Println("Error writing packet", m)
@@ -232,6 +277,85 @@ func (srv *Server) serve(w dns.ResponseWriter, req *dns.Msg, z *Zone) {
 	return
 }
 
+// answerQTypes returns the record types findLabels should look for to
+// answer a query for qtype.
+func answerQTypes(qtype uint16) qTypes {
+	if qtype == dns.TypeA || qtype == dns.TypeAAAA {
+		// ANAME records (stored as dns.TypeMD) take precedence over A/AAAA
+		return qTypes{dns.TypeMF, dns.TypeCNAME, dns.TypeMD, qtype}
+	}
+	return qTypes{dns.TypeMF, dns.TypeCNAME, qtype}
+}
+
+// pickRecords returns the records of type labelQtype from label to answer
+// a query for name, resolving ANAMEs as needed.
+func (srv *Server) pickRecords(z *Zone, label *Label, labelQtype uint16, name string, qtype uint16, ip net.IP) ([]dns.RR, error) {
+	if labelQtype == dns.TypeMD {
+		rrs, err := srv.resolveANAME(label, name, qtype, ip)
+		if err == nil {
+			return rrs, nil
+		}
+		if len(label.Records[qtype]) == 0 {
+			return nil, err
+		}
+		log.Printf("[zone %s] %s", z.Origin, err)
+		// fall back to the local records
+		labelQtype = qtype
+	}
+
+	var rrs []dns.RR
+	for _, record := range label.Picker(labelQtype, label.MaxHosts) {
+		rr := dns.Copy(record.RR)
+		rr.Header().Name = name
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+// maxCNAMEChain is how many in-zone CNAMEs are followed for an answer
+const maxCNAMEChain = 8
+
+// followCNAMEs follows the CNAME at the end of rrs (an answer for label) as
+// long as it points to a name in the zone, with the same targeting as the
+// answer, and returns the records to append to the answer.
+func (srv *Server) followCNAMEs(z *Zone, label string, rrs []dns.RR, qtype uint16, targets []string, ip net.IP) []dns.RR {
+	var answer []dns.RR
+	seen := map[string]bool{label: true}
+
+	for i := 0; i < maxCNAMEChain && len(rrs) > 0; i++ {
+		cname, ok := rrs[len(rrs)-1].(*dns.CNAME)
+		if !ok {
+			break
+		}
+		name, ok := z.LabelName(cname.Target)
+		if !ok || seen[name] {
+			break
+		}
+		seen[name] = true
+		if _, ok := z.FindDelegation(name); ok {
+			break
+		}
+
+		target, labelQtype := z.findLabels(name, targets, answerQTypes(qtype))
+		if target == nil {
+			break
+		}
+		if labelQtype == 0 {
+			labelQtype = qtype
+		}
+
+		var err error
+		rrs, err = srv.pickRecords(z, target, labelQtype, cname.Target, qtype, ip)
+		if err != nil {
+			log.Printf("[zone %s] %s", z.Origin, err)
+			break
+		}
+		answer = append(answer, rrs...)
+	}
+
+	return answer
+}
+
 func statusRR(label string) []dns.RR {
 	h := dns.RR_Header{Ttl: 1, Class: dns.ClassINET, Rrtype: dns.TypeTXT}
 	h.Name = label
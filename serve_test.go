@@ -15,6 +15,8 @@ const (
 	PORT = ":8853"
 )
 
+var testTsigSecrets = map[string]string{"transfer-key.": "c2VjcmV0IGZvciB0aGUgdHJhbnNmZXIga2V5"}
+
 type ServeSuite struct {
 }
 
@@ -26,7 +28,11 @@ func (s *ServeSuite) SetUpSuite(c *C) {
 	metrics := NewMetrics()
 	go metrics.Updater()
 
+	startStubResolver(STUBRESOLVER)
+
 	srv := Server{}
+	srv.SetResolver(testResolver())
+	srv.SetTsigSecrets(testTsigSecrets)
 
 	Zones := make(Zones)
 	srv.setupPgeodnsZone(Zones)
@@ -72,10 +78,22 @@ func (s *ServeSuite) TestServing(c *C) {
 	c.Check(ip.String(), Equals, "192.168.1.2")
 	c.Check(int(r.Answer[0].Header().Ttl), Equals, 601)
 
+	// records with their own TTL, the answer has the lowest TTL
+	r = exchange(c, "ttl-mixed.test.example.com.", dns.TypeA)
+	c.Assert(r.Answer, HasLen, 2)
+	c.Check(int(r.Answer[0].Header().Ttl), Equals, 60)
+	c.Check(int(r.Answer[1].Header().Ttl), Equals, 60)
+	r = exchange(c, "ttl-mixed.test.example.com.", dns.TypeMX)
+	c.Check(int(r.Answer[0].Header().Ttl), Equals, 7200)
+
 	r = exchange(c, "test.example.com.", dns.TypeSOA)
 	soa := r.Answer[0].(*dns.SOA)
 	serial := soa.Serial
 	c.Check(int(serial), Equals, 3)
+	// the default SOA timers
+	c.Check(soa.Ns, Equals, "ns1.example.net.")
+	c.Check([]uint32{soa.Refresh, soa.Retry, soa.Expire, soa.Minttl}, DeepEquals, []uint32{5400, 5400, 1209600, 3600})
+	c.Check(soa.Hdr.Ttl, Equals, uint32(3600))
 
 	// no AAAA records for 'bar', so check we get a soa record back
 	r = exchange(c, "test.example.com.", dns.TypeAAAA)
@@ -91,6 +109,11 @@ func (s *ServeSuite) TestServing(c *C) {
 	r = exchange(c, "test.example.com.", dns.TypeSPF)
 	c.Check(r.Answer[0].(*dns.SPF).Txt[0], Equals, "v=spf1 ~all")
 
+	// TXT records longer than 255 bytes are split
+	r = exchange(c, "long-txt.test.example.com.", dns.TypeTXT)
+	c.Assert(r.Answer, HasLen, 1)
+	c.Check(r.Answer[0].(*dns.TXT).Txt, HasLen, 2)
+
 	//SRV
 	r = exchange(c, "_sip._tcp.test.example.com.", dns.TypeSRV)
 	c.Check(r.Answer[0].(*dns.SRV).Target, Equals, "sipserver.example.com.")
@@ -98,12 +121,25 @@ func (s *ServeSuite) TestServing(c *C) {
 	c.Check(r.Answer[0].(*dns.SRV).Priority, Equals, uint16(10))
 	c.Check(r.Answer[0].(*dns.SRV).Weight, Equals, uint16(100))
 
+	// HTTPS
+	r = exchange(c, "test.example.com.", TypeHTTPS)
+	c.Assert(r.Answer, HasLen, 1)
+	c.Check(r.Answer[0].Header().Rrtype, Equals, TypeHTTPS)
+	c.Check(r.Answer[0].(*dns.RFC3597).Rdata, Equals, "0001"+"00"+"00010006026832026833"+"00040004c0a80102")
+
 	// MX
 	r = exchange(c, "test.example.com.", dns.TypeMX)
 	c.Check(r.Answer[0].(*dns.MX).Mx, Equals, "mx.example.net.")
 	c.Check(r.Answer[1].(*dns.MX).Mx, Equals, "mx2.example.net.")
 	c.Check(r.Answer[1].(*dns.MX).Preference, Equals, uint16(20))
 
+	// Additional section for in-zone targets
+	r = exchange(c, "mail.test.example.com.", dns.TypeMX)
+	c.Check(r.Answer, HasLen, 1)
+	c.Assert(r.Extra, HasLen, 2)
+	c.Check(r.Extra[0].(*dns.A).A.String(), Equals, "192.168.2.1")
+	c.Check(r.Extra[1].(*dns.AAAA).AAAA.String(), Equals, "fd06:c1d3:e902::25")
+
 	// Verify the first A record was created
 	r = exchange(c, "a.b.c.test.example.com.", dns.TypeA)
 	ip = r.Answer[0].(*dns.A).A
@@ -140,6 +176,45 @@ func (s *ServeSuite) TestServing(c *C) {
 	c.Check(r.Rcode, Equals, dns.RcodeSuccess)
 	name := r.Answer[0].(*dns.PTR).Ptr
 	c.Check(name, Equals, "bar.example.com.")
+
+	// zones from master files
+	r = exchange(c, "mail.test.example.net.", dns.TypeA)
+	c.Assert(r.Answer, HasLen, 1)
+	c.Check(r.Answer[0].(*dns.A).A.String(), Equals, "192.0.2.25")
+	c.Check(int(r.Answer[0].Header().Ttl), Equals, 300)
+
+	r = exchange(c, "test.example.net.", dns.TypeSOA)
+	c.Check(int(r.Answer[0].(*dns.SOA).Serial), Equals, 2016020101)
+}
+
+func (s *ServeSuite) TestServingDelegation(c *C) {
+	for _, name := range []string{"glue.test.example.org.", "www.glue.test.example.org.", "ns1.glue.test.example.org."} {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeNS} {
+			r := exchange(c, name, qtype)
+			c.Check(r.Rcode, Equals, dns.RcodeSuccess)
+			c.Check(r.Authoritative, Equals, false)
+			c.Check(r.Answer, HasLen, 0)
+			// all the nameservers, more than max_hosts
+			c.Assert(r.Ns, HasLen, 3)
+			c.Check(r.Ns[0].Header().Name, Equals, "glue.test.example.org.")
+			c.Check(r.Ns[0].(*dns.NS).Ns, Equals, "ns1.glue.test.example.org.")
+			// glue
+			c.Assert(r.Extra, HasLen, 1)
+			c.Check(r.Extra[0].Header().Name, Equals, "ns1.glue.test.example.org.")
+			c.Check(r.Extra[0].(*dns.A).A.String(), Equals, "192.168.3.1")
+		}
+	}
+
+	// no glue for out of zone nameservers
+	r := exchange(c, "foo.sub.test.example.org.", dns.TypeA)
+	c.Check(r.Authoritative, Equals, false)
+	c.Check(r.Ns, HasLen, 2)
+	c.Check(r.Extra, HasLen, 0)
+
+	// the apex NS records are still an authoritative answer
+	r = exchange(c, "test.example.com.", dns.TypeNS)
+	c.Check(r.Authoritative, Equals, true)
+	c.Check(r.Answer, HasLen, 2)
 }
 
 func (s *ServeSuite) TestServingMixedCase(c *C) {
@@ -177,6 +252,37 @@ func (s *ServeSuite) TestCname(c *C) {
 	c.Check(results, HasLen, 2)
 }
 
+func (s *ServeSuite) TestCnameChain(c *C) {
+	// in-zone CNAMEs are followed
+	r := exchange(c, "www-cname.test.example.com.", dns.TypeA)
+	c.Assert(r.Answer, HasLen, 2)
+	c.Check(r.Answer[0].(*dns.CNAME).Target, Equals, "bar.test.example.com.")
+	c.Check(r.Answer[1].Header().Name, Equals, "bar.test.example.com.")
+	c.Check(r.Answer[1].(*dns.A).A.String(), Equals, "192.168.1.2")
+	c.Check(int(r.Answer[1].Header().Ttl), Equals, 601)
+
+	r = exchange(c, "cname-chain.test.example.com.", dns.TypeA)
+	c.Assert(r.Answer, HasLen, 3)
+	c.Check(r.Answer[0].(*dns.CNAME).Target, Equals, "www-cname.test.example.com.")
+	c.Check(r.Answer[1].(*dns.CNAME).Target, Equals, "bar.test.example.com.")
+	c.Check(r.Answer[2].(*dns.A).A.String(), Equals, "192.168.1.2")
+
+	// no AAAA records at the end of the chain
+	r = exchange(c, "www-cname.test.example.com.", dns.TypeAAAA)
+	c.Check(r.Answer, HasLen, 1)
+	c.Check(r.Rcode, Equals, dns.RcodeSuccess)
+
+	// CNAME queries just get the CNAME
+	r = exchange(c, "www-cname.test.example.com.", dns.TypeCNAME)
+	c.Check(r.Answer, HasLen, 1)
+
+	// loops stop when a name is repeated
+	r = exchange(c, "cname-loop-a.test.example.com.", dns.TypeA)
+	c.Check(r.Rcode, Equals, dns.RcodeSuccess)
+	c.Assert(r.Answer, HasLen, 2)
+	c.Check(r.Answer[1].(*dns.CNAME).Target, Equals, "cname-loop-a.test.example.com.")
+}
+
 func (s *ServeSuite) TestUnknownDomain(c *C) {
 	r := exchange(c, "no.such.domain.", dns.TypeAAAA)
 	c.Assert(r.Rcode, Equals, dns.RcodeRefused)
@@ -199,6 +305,12 @@ func (s *ServeSuite) TestServingAliases(c *C) {
 		c.Check(r.Answer[0].(*dns.CNAME).Target, Equals, "geo-europe.bitnames.com.")
 	}
 
+	// Alias loops through targeted labels
+	r = exchange(c, "alias-loop.test.example.com.", dns.TypeA)
+	c.Check(r.Answer[0].(*dns.A).A.String(), Equals, "192.168.1.8")
+	r = exchangeSubnet(c, "alias-loop.test.example.com.", dns.TypeA, "192.0.2.1")
+	c.Check(r.Rcode, Equals, dns.RcodeServerFailure)
+
 	// Alias to Ns records
 	r = exchange(c, "sub-alias.test.example.org.", dns.TypeNS)
 	c.Check(r.Answer[0].(*dns.NS).Ns, Equals, "ns1.example.com.")
@@ -298,3 +410,234 @@ func dorequest(c *C, msg *dns.Msg) *dns.Msg {
 	}
 	return r
 }
+
+func transfer(zone string, tsigKey string) ([]dns.RR, error) {
+	msg := new(dns.Msg)
+	msg.SetAxfr(zone)
+	return transferMsg(msg, tsigKey)
+}
+
+func transferMsg(msg *dns.Msg, tsigKey string) ([]dns.RR, error) {
+	t := new(dns.Transfer)
+	if len(tsigKey) > 0 {
+		t.TsigSecret = testTsigSecrets
+		msg.SetTsig(tsigKey, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+	env, err := t.In(msg, "127.0.0.1"+PORT)
+	if err != nil {
+		return nil, err
+	}
+	var rrs []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			return rrs, e.Error
+		}
+		rrs = append(rrs, e.RR...)
+	}
+	return rrs, nil
+}
+
+func (s *ServeSuite) TestTransfer(c *C) {
+	rrs, err := transfer("test.example.com.", "")
+	c.Assert(err, IsNil)
+	c.Assert(len(rrs) > 2, Equals, true)
+	c.Check(rrs[0].Header().Rrtype, Equals, dns.TypeSOA)
+	c.Check(rrs[len(rrs)-1].Header().Rrtype, Equals, dns.TypeSOA)
+
+	found := func(name string, qtype uint16) []dns.RR {
+		var list []dns.RR
+		for _, rr := range rrs[1 : len(rrs)-1] {
+			if rr.Header().Name == name && rr.Header().Rrtype == qtype {
+				list = append(list, rr)
+			}
+		}
+		return list
+	}
+
+	// the "europe" view
+	mx := found("test.example.com.", dns.TypeMX)
+	c.Assert(mx, HasLen, 1)
+	c.Check(mx[0].(*dns.MX).Mx, Equals, "mx-eu.example.net.")
+	c.Check(found("test.example.com.", dns.TypeNS), HasLen, 2)
+	c.Check(found("test.example.com.", dns.TypeSOA), HasLen, 0)
+
+	cname := found("www.test.example.com.", dns.TypeCNAME)
+	c.Assert(cname, HasLen, 1)
+	c.Check(cname[0].(*dns.CNAME).Target, Equals, "geo-europe.bitnames.com.")
+	// aliases are resolved
+	cname = found("www-alias.test.example.com.", dns.TypeCNAME)
+	c.Assert(cname, HasLen, 1)
+	c.Check(cname[0].(*dns.CNAME).Target, Equals, "geo-europe.bitnames.com.")
+	best := highestWeight(Records{
+		{RR: &dns.CNAME{Target: "a."}, Weight: 1},
+		{RR: &dns.CNAME{Target: "b."}, Weight: 5},
+		{RR: &dns.CNAME{Target: "c."}, Weight: 5},
+	})
+	c.Check(best.RR.(*dns.CNAME).Target, Equals, "b.")
+
+	// all the records (but not the ones with weight 0)
+	var ips []string
+	for _, rr := range found("tmpl.test.example.com.", dns.TypeA) {
+		ips = append(ips, rr.(*dns.A).A.String())
+	}
+	c.Check(ips, HasLen, 3)
+	c.Check(strings.Join(ips, " "), Not(Matches), ".*192.168.10.2.*")
+
+	c.Check(found("bar.test.example.com.", dns.TypeA), HasLen, 1)
+	c.Check(found("aname.test.example.com.", dns.TypeA), HasLen, 0)
+
+	// targeted labels like "mx1.europe" aren't names in the zone, they're
+	// used through the view
+	for _, rr := range rrs {
+		c.Check(rr.Header().Name, Not(Matches), `.*(\.europe\.|\[|\.as15169\.).*`)
+	}
+	a := found("mx1.test.example.com.", dns.TypeA)
+	c.Assert(a, HasLen, 1)
+	c.Check(a[0].(*dns.A).A.String(), Equals, "192.168.2.2")
+
+	// no transfers for zones without the transfer option, over UDP or
+	// without the TSIG key
+	_, err = transfer("test.example.org.", "")
+	c.Check(err, NotNil)
+	_, err = transfer("test.example.net.", "")
+	c.Check(err, NotNil)
+
+	r := exchange(c, "test.example.com.", dns.TypeAXFR)
+	c.Check(r.Rcode, Equals, dns.RcodeRefused)
+
+	// with TSIG
+	rrs, err = transfer("test.example.net.", "transfer-key.")
+	c.Assert(err, IsNil)
+	var www, wildcard int
+	for _, rr := range rrs {
+		switch rr.Header().Name {
+		case "www.test.example.net.":
+			www++
+		case "*.users.test.example.net.":
+			wildcard++
+		}
+	}
+	c.Check(www, Equals, 2)
+	c.Check(wildcard, Equals, 1)
+}
+
+func (s *ServeSuite) TestIncrementalTransfer(c *C) {
+	soa := exchange(c, "test.example.com.", dns.TypeSOA).Answer[0].(*dns.SOA)
+
+	// up to date
+	msg := new(dns.Msg)
+	msg.SetIxfr("test.example.com.", soa.Serial, soa.Ns, soa.Mbox)
+	rrs, err := transferMsg(msg, "")
+	c.Assert(err, IsNil)
+	c.Assert(rrs, HasLen, 1)
+	c.Check(rrs[0].(*dns.SOA).Serial, Equals, soa.Serial)
+
+	// without history the whole zone, like AXFR
+	msg.SetIxfr("test.example.com.", 1, soa.Ns, soa.Mbox)
+	rrs, err = transferMsg(msg, "")
+	c.Assert(err, IsNil)
+	axfr, err := transfer("test.example.com.", "")
+	c.Assert(err, IsNil)
+	c.Check(len(rrs), Equals, len(axfr))
+
+	// over UDP just the SOA
+	msg.SetIxfr("test.example.com.", 1, soa.Ns, soa.Mbox)
+	r := dorequest(c, msg)
+	c.Check(r.Rcode, Equals, dns.RcodeSuccess)
+	c.Assert(r.Answer, HasLen, 1)
+	c.Check(r.Answer[0].(*dns.SOA).Serial, Equals, soa.Serial)
+
+	// the serial of the secondary is required
+	r = exchange(c, "test.example.com.", dns.TypeIXFR)
+	c.Check(r.Rcode, Equals, dns.RcodeFormatError)
+}
+
+func (s *ServeSuite) TestNotify(c *C) {
+	notified := make(chan *dns.Msg, 1)
+	mux := dns.NewServeMux()
+	mux.HandleFunc("notify.example.com.", func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		w.WriteMsg(m)
+		notified <- req
+	})
+	secondary := &dns.Server{Addr: ":8856", Net: "udp", Handler: mux}
+	started := make(chan error, 1)
+	secondary.NotifyStartedFunc = func() { started <- nil }
+	go func() { started <- secondary.ListenAndServe() }()
+	c.Assert(<-started, IsNil)
+	defer secondary.Shutdown()
+
+	zone := NewZone("notify.example.com")
+	zone.AddLabel("")
+	zone.Options.Serial = 42
+	zone.Options.Transfer = &ZoneTransfer{Notify: []string{"127.0.0.1:8856"}, Keys: []string{"transfer-key."}}
+	setupSOA(zone)
+
+	srv := &Server{tsigSecrets: testTsigSecrets}
+	zones := make(Zones)
+	srv.addHandler(zones, "notify.example.com", zone)
+	defer dns.HandleRemove("notify.example.com")
+
+	select {
+	case req := <-notified:
+		c.Check(req.Opcode, Equals, dns.OpcodeNotify)
+		c.Check(req.Question[0].Name, Equals, "notify.example.com.")
+		c.Assert(req.Answer, HasLen, 1)
+		c.Check(req.Answer[0].(*dns.SOA).Serial, Equals, uint32(42))
+		c.Assert(req.IsTsig(), NotNil)
+		c.Check(req.IsTsig().Algorithm, Equals, dns.HmacSHA256)
+	case <-time.After(2 * time.Second):
+		c.Fatal("no NOTIFY")
+	}
+
+	reload := func(serial int) *dns.Msg {
+		z := NewZone("notify.example.com")
+		z.AddLabel("")
+		z.Options.Serial = serial
+		z.Options.Transfer = zone.Options.Transfer
+		setupSOA(z)
+		srv.addHandler(zones, "notify.example.com", z)
+		select {
+		case req := <-notified:
+			return req
+		case <-time.After(200 * time.Millisecond):
+			return nil
+		}
+	}
+
+	// only when the serial changed
+	c.Check(reload(42), IsNil)
+
+	// signed with the algorithm of the key
+	srv.SetTsigAlgorithms(map[string]string{"transfer-key.": dns.HmacSHA512})
+	req := reload(43)
+	c.Assert(req, NotNil)
+	c.Check(req.Answer[0].(*dns.SOA).Serial, Equals, uint32(43))
+	c.Assert(req.IsTsig(), NotNil)
+	c.Check(req.IsTsig().Algorithm, Equals, dns.HmacSHA512)
+
+	// and not with -checkconfig
+	*flagcheckconfig = true
+	defer func() { *flagcheckconfig = false }()
+	c.Check(reload(44), IsNil)
+}
+
+func (s *ServeSuite) TestTsigAlgorithms(c *C) {
+	conf := &AppConfig{TSIG: map[string]*struct {
+		Secret    string
+		Algorithm string
+	}{
+		"Transfer-Key": {Secret: "c2VjcmV0"},
+		"md5-key.":     {Secret: "c2VjcmV0", Algorithm: "HMAC-MD5.SIG-ALG.REG.INT."},
+		"sha512-key":   {Secret: "c2VjcmV0", Algorithm: "hmac-sha512"},
+		"gss-key":      {Secret: "c2VjcmV0", Algorithm: "gss-tsig"},
+	}}
+	c.Check(conf.TsigAlgorithms(), DeepEquals, map[string]string{
+		"transfer-key.": dns.HmacSHA256,
+		"md5-key.":      dns.HmacMD5,
+		"sha512-key.":   dns.HmacSHA512,
+	})
+	c.Check(conf.TsigSecrets(), HasLen, 3)
+	c.Check((&Server{}).tsigAlgorithm("transfer-key."), Equals, dns.HmacSHA256)
+}
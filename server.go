@@ -2,7 +2,6 @@ package main
 
 import (
 	"log"
-	"time"
 
 	"github.com/abh/geodns/querylog"
 	"github.com/miekg/dns"
@@ -10,6 +9,19 @@ import (
 
 type Server struct {
 	queryLogger querylog.QueryLogger
+	resolver    *Resolver
+	tsigSecrets map[string]string
+	// the algorithm of the TSIG keys, hmac-sha256 if not set
+	tsigAlgorithms map[string]string
+	secondaries    map[string]*secondaryZone
+	httpZones      *httpZoneSource
+	kvZones        *kvZoneSource
+
+	// the zone directory source, the zone files by zone name from the
+	// zone directory and the zones installed from the other zone sources
+	dirZones      *dirZoneSource
+	zonePaths     map[string][]string
+	externalZones map[string]bool
 }
 
 func NewServer() *Server {
@@ -22,6 +34,32 @@ func (srv *Server) SetQueryLogger(logger querylog.QueryLogger) {
 	srv.queryLogger = logger
 }
 
+// SetResolver sets the resolver used to look up the targets of ANAME
+// records.
+func (srv *Server) SetResolver(resolver *Resolver) {
+	srv.resolver = resolver
+}
+
+// SetTsigSecrets sets the TSIG secrets (by fully qualified key name) for
+// signed requests, like zone transfers.
+func (srv *Server) SetTsigSecrets(secrets map[string]string) {
+	srv.tsigSecrets = secrets
+}
+
+// SetTsigAlgorithms sets the algorithms of the TSIG keys (by fully qualified
+// key name) for the requests and NOTIFYs geodns signs.
+func (srv *Server) SetTsigAlgorithms(algorithms map[string]string) {
+	srv.tsigAlgorithms = algorithms
+}
+
+// tsigAlgorithm returns the algorithm of the TSIG key.
+func (srv *Server) tsigAlgorithm(key string) string {
+	if algorithm, ok := srv.tsigAlgorithms[key]; ok {
+		return algorithm
+	}
+	return dns.HmacSHA256
+}
+
 func (srv *Server) setupServerFunc(Zone *Zone) func(dns.ResponseWriter, *dns.Msg) {
 	return func(w dns.ResponseWriter, r *dns.Msg) {
 		srv.serve(w, r, Zone)
@@ -35,6 +73,9 @@ func (srv *Server) listenAndServe(ip string) {
 	for _, prot := range prots {
 		go func(p string) {
 			server := &dns.Server{Addr: ip, Net: p}
+			if len(srv.tsigSecrets) > 0 {
+				server.TsigSecret = srv.tsigSecrets
+			}
 
 			log.Printf("Opening on %s %s", ip, p)
 			if err := server.ListenAndServe(); err != nil {
@@ -48,13 +89,11 @@ func (srv *Server) listenAndServe(ip string) {
 func (srv *Server) addHandler(zones Zones, name string, config *Zone) {
 	oldZone := zones[name]
 	config.SetupMetrics(oldZone)
+	config.setupTransferHistory(oldZone)
 	zones[name] = config
 	dns.HandleFunc(name, srv.setupServerFunc(config))
-}
-
-func (srv *Server) zonesReader(dirName string, zones Zones) {
-	for {
-		srv.zonesReadDir(dirName, zones)
-		time.Sleep(5 * time.Second)
+	// the secondaries only need to know about a new serial
+	if oldZone == nil || oldZone.Options.Serial != config.Options.Serial {
+		srv.sendNotify(config)
 	}
 }
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// The vendored dns library predates SVCB and HTTPS (RFC 9460), so the
+// records are built in wire format here and served as RFC 3597 "unknown"
+// records with the right type code.
+const (
+	TypeSVCB  uint16 = 64
+	TypeHTTPS uint16 = 65
+)
+
+// SvcParamKeys from RFC 9460; other keys can be given as "keyNNNNN".
+var svcParamKeys = map[string]uint16{
+	"mandatory":       0,
+	"alpn":            1,
+	"no-default-alpn": 2,
+	"port":            3,
+	"ipv4hint":        4,
+	"ech":             5,
+	"ipv6hint":        6,
+}
+
+type svcParam struct {
+	key   uint16
+	value []byte
+}
+
+type svcParams []svcParam
+
+// svcParamError is a problem with one of the SvcParams, so it can be
+// reported with the path of the parameter.
+type svcParamError struct {
+	name string
+	err  error
+}
+
+func (e *svcParamError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.name, e.err)
+}
+
+func (p svcParams) Len() int           { return len(p) }
+func (p svcParams) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p svcParams) Less(i, j int) bool { return p[i].key < p[j].key }
+
+func svcParamKey(name string) (uint16, error) {
+	name = strings.ToLower(name)
+	if key, ok := svcParamKeys[name]; ok {
+		return key, nil
+	}
+	if strings.HasPrefix(name, "key") {
+		key, err := strconv.ParseUint(name[3:], 10, 16)
+		if err == nil && key != 65535 {
+			return uint16(key), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown SvcParamKey '%s'", name)
+}
+
+// svcParamName returns the name of the key, "keyNNNNN" if it doesn't have
+// one.
+func svcParamName(key uint16) string {
+	for name, k := range svcParamKeys {
+		if k == key {
+			return name
+		}
+	}
+	return fmt.Sprintf("key%d", key)
+}
+
+// stringList accepts a single comma separated string or a list of strings
+// (the JSON decoder gives us the latter as []interface{}).
+func stringList(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return splitValueList(v), nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, s := range v {
+			str, ok := s.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got '%v'", s)
+			}
+			list = append(list, str)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("expected a string or a list of strings, got '%v'", v)
+}
+
+// splitValueList splits a comma separated list, where "\," is a comma in a
+// value and "\\" a backslash (RFC 9460, appendix A.1).
+func splitValueList(s string) []string {
+	var list []string
+	var value []byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			value = append(value, s[i])
+		case c == ',':
+			list = append(list, string(value))
+			value = value[:0]
+		default:
+			value = append(value, c)
+		}
+	}
+	return append(list, string(value))
+}
+
+// escapeValue returns the value of an unknown SvcParamKey as a string, with
+// the bytes that aren't printable (and backslashes) as \xNN escapes.
+func escapeValue(value []byte) string {
+	var b []byte
+	for _, c := range value {
+		if c < 0x20 || c > 0x7e || c == '\\' {
+			b = append(b, fmt.Sprintf("\\x%02x", c)...)
+			continue
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// unescapeValue is the reverse of escapeValue.
+func unescapeValue(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+3 >= len(s) || s[i+1] != 'x' {
+			return nil, fmt.Errorf("invalid escape in '%s', expected \\xNN", s)
+		}
+		c, err := strconv.ParseUint(s[i+2:i+4], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid escape in '%s', expected \\xNN", s)
+		}
+		b = append(b, byte(c))
+		i += 3
+	}
+	return b, nil
+}
+
+func svcParamValue(key uint16, v interface{}) ([]byte, error) {
+	switch key {
+	case 0: // mandatory
+		names, err := stringList(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("at least one key is needed")
+		}
+		keys := make([]int, 0, len(names))
+		for _, name := range names {
+			k, err := svcParamKey(name)
+			if err != nil {
+				return nil, err
+			}
+			if k == 0 {
+				return nil, fmt.Errorf("mandatory can't include itself")
+			}
+			keys = append(keys, int(k))
+		}
+		sort.Ints(keys)
+		for i := 1; i < len(keys); i++ {
+			if keys[i] == keys[i-1] {
+				return nil, fmt.Errorf("%s is listed more than once", svcParamName(uint16(keys[i])))
+			}
+		}
+		b := make([]byte, 2*len(keys))
+		for i, k := range keys {
+			binary.BigEndian.PutUint16(b[2*i:], uint16(k))
+		}
+		return b, nil
+
+	case 1: // alpn
+		ids, err := stringList(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("at least one id is needed")
+		}
+		var b []byte
+		for _, id := range ids {
+			if len(id) == 0 || len(id) > 255 {
+				return nil, fmt.Errorf("invalid alpn id '%s'", id)
+			}
+			b = append(b, byte(len(id)))
+			b = append(b, id...)
+		}
+		return b, nil
+
+	case 2: // no-default-alpn
+		if v != nil {
+			b, err := valueToBool(v)
+			if err != nil {
+				return nil, err
+			}
+			if !b {
+				return nil, nil
+			}
+		}
+		return []byte{}, nil
+
+	case 3: // port
+		port, err := valueToInt(v)
+		if err != nil {
+			return nil, err
+		}
+		if port < 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %d", port)
+		}
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(port))
+		return b, nil
+
+	case 4, 6: // ipv4hint, ipv6hint
+		addrs, err := stringList(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("at least one address is needed")
+		}
+		var b []byte
+		for _, addr := range addrs {
+			ip := net.ParseIP(strings.TrimSpace(addr))
+			switch {
+			case ip == nil:
+				return nil, fmt.Errorf("invalid IP address '%s'", addr)
+			case key == 4 && ip.To4() != nil:
+				b = append(b, ip.To4()...)
+			case key == 6 && ip.To4() == nil:
+				b = append(b, ip.To16()...)
+			default:
+				return nil, fmt.Errorf("wrong address family for '%s'", addr)
+			}
+		}
+		return b, nil
+
+	case 5: // ech
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("ech must be a base64 string")
+		}
+		return base64.StdEncoding.DecodeString(str)
+	}
+
+	// keyNNNNN, the value is used as-is (with \xNN escapes for other bytes)
+	if v == nil {
+		return []byte{}, nil
+	}
+	str, err := valueToString(v)
+	if err != nil {
+		return nil, err
+	}
+	return unescapeValue(str)
+}
+
+// newSVCB builds an SVCB or HTTPS record from the zone data. The params
+// are an object with the SvcParamKey names as keys, for example
+//
+//	{ "alpn": [ "h2", "h3" ], "port": 8443, "ipv4hint": [ "192.0.2.1" ] }
+func newSVCB(h dns.RR_Header, priority uint16, target string, params map[string]interface{}) (dns.RR, error) {
+	if priority == 0 && len(params) > 0 {
+		return nil, fmt.Errorf("AliasMode (priority 0) records can't have parameters")
+	}
+
+	rdata := make([]byte, 2+256)
+	binary.BigEndian.PutUint16(rdata, priority)
+	off, err := dns.PackDomainName(dns.Fqdn(target), rdata, 2, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target '%s': %s", target, err)
+	}
+	rdata = rdata[:off]
+
+	var list svcParams
+	for name, v := range params {
+		key, err := svcParamKey(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := svcParamValue(key, v)
+		if err != nil {
+			return nil, &svcParamError{name, err}
+		}
+		if value == nil {
+			continue
+		}
+		if len(value) > 65535 {
+			return nil, &svcParamError{name, fmt.Errorf("too long")}
+		}
+		list = append(list, svcParam{key: key, value: value})
+	}
+	sort.Sort(list)
+
+	// the keys can only be given once (like "port" and "key3") and the
+	// mandatory keys have to be there (RFC 9460 section 2.2 and 8)
+	present := make(map[uint16]bool, len(list))
+	for i, p := range list {
+		if i > 0 && list[i-1].key == p.key {
+			return nil, fmt.Errorf("duplicate SvcParamKey %s", svcParamName(p.key))
+		}
+		present[p.key] = true
+	}
+	if len(list) > 0 && list[0].key == 0 {
+		mandatory := list[0].value
+		for i := 0; i+1 < len(mandatory); i += 2 {
+			if key := binary.BigEndian.Uint16(mandatory[i:]); !present[key] {
+				return nil, fmt.Errorf("mandatory %s is missing", svcParamName(key))
+			}
+		}
+	}
+	// no-default-alpn only makes sense with alpn (RFC 9460 section 7.1.1)
+	if present[2] && !present[1] {
+		return nil, &svcParamError{"no-default-alpn", fmt.Errorf("alpn is needed too")}
+	}
+
+	for _, p := range list {
+		b := make([]byte, 4, 4+len(p.value))
+		binary.BigEndian.PutUint16(b, p.key)
+		binary.BigEndian.PutUint16(b[2:], uint16(len(p.value)))
+		rdata = append(rdata, append(b, p.value...)...)
+	}
+
+	return &dns.RFC3597{Hdr: h, Rdata: hex.EncodeToString(rdata)}, nil
+}
+
+// svcbData returns the zone data for an SVCB or HTTPS record in the RFC 3597
+// form (from a master file or a zone transfer), the reverse of newSVCB.
+func svcbData(rr *dns.RFC3597) (map[string]interface{}, error) {
+	rdata, err := hex.DecodeString(rr.Rdata)
+	if err != nil || len(rdata) < 3 {
+		return nil, fmt.Errorf("invalid rdata")
+	}
+	target, off, err := dns.UnpackDomainName(rdata, 2)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target: %s", err)
+	}
+	data := map[string]interface{}{
+		"priority": float64(binary.BigEndian.Uint16(rdata)),
+		"target":   target,
+	}
+
+	params := make(map[string]interface{})
+	for off < len(rdata) {
+		if off+4 > len(rdata) {
+			return nil, fmt.Errorf("invalid SvcParams")
+		}
+		key := binary.BigEndian.Uint16(rdata[off:])
+		length := int(binary.BigEndian.Uint16(rdata[off+2:]))
+		off += 4
+		if off+length > len(rdata) {
+			return nil, fmt.Errorf("invalid SvcParams")
+		}
+		value := rdata[off : off+length]
+		off += length
+
+		name := svcParamName(key)
+		switch key {
+		case 0: // mandatory
+			var names []interface{}
+			for i := 0; i+1 < len(value); i += 2 {
+				names = append(names, svcParamName(binary.BigEndian.Uint16(value[i:])))
+			}
+			params[name] = names
+		case 1: // alpn
+			var ids []interface{}
+			for i := 0; i < len(value); {
+				n := int(value[i])
+				if i+1+n > len(value) {
+					return nil, fmt.Errorf("invalid alpn")
+				}
+				ids = append(ids, string(value[i+1:i+1+n]))
+				i += 1 + n
+			}
+			params[name] = ids
+		case 2: // no-default-alpn
+			params[name] = true
+		case 3: // port
+			if len(value) != 2 {
+				return nil, fmt.Errorf("invalid port")
+			}
+			params[name] = float64(binary.BigEndian.Uint16(value))
+		case 4, 6: // ipv4hint, ipv6hint
+			size := net.IPv4len
+			if key == 6 {
+				size = net.IPv6len
+			}
+			var addrs []interface{}
+			for i := 0; i+size <= len(value); i += size {
+				addrs = append(addrs, net.IP(value[i:i+size]).String())
+			}
+			params[name] = addrs
+		case 5: // ech
+			params[name] = base64.StdEncoding.EncodeToString(value)
+		default:
+			params[name] = escapeValue(value)
+		}
+	}
+	if len(params) > 0 {
+		data["params"] = params
+	}
+	return data, nil
+}
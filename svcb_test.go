@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+
+	"github.com/miekg/dns"
+	. "gopkg.in/check.v1"
+)
+
+type SVCBSuite struct {
+}
+
+var _ = Suite(&SVCBSuite{})
+
+// Test vectors from RFC 9460, Appendix D
+func (s *SVCBSuite) TestWireFormat(c *C) {
+	tests := []struct {
+		priority uint16
+		target   string
+		params   map[string]interface{}
+		wire     string
+	}{
+		{0, "foo.example.com.", nil,
+			"0000" + "03666f6f076578616d706c6503636f6d00"},
+		{1, ".", nil,
+			"0001" + "00"},
+		{16, "foo.example.com.", map[string]interface{}{"port": float64(53)},
+			"0010" + "03666f6f076578616d706c6503636f6d00" + "000300020035"},
+		{1, "foo.example.com.", map[string]interface{}{"key667": "hello"},
+			"0001" + "03666f6f076578616d706c6503636f6d00" + "029b000568656c6c6f"},
+		{1, "foo.example.com.", map[string]interface{}{"ipv6hint": []interface{}{"2001:db8::1", "2001:db8::53:1"}},
+			"0001" + "03666f6f076578616d706c6503636f6d00" + "00060020" +
+				"20010db8000000000000000000000001" + "20010db8000000000000000000530001"},
+		{16, "foo.example.org.", map[string]interface{}{
+			"alpn":      []interface{}{"h2", "h3-19"},
+			"mandatory": []interface{}{"ipv4hint", "alpn"},
+			"ipv4hint":  "192.0.2.1",
+		},
+			"0010" + "03666f6f076578616d706c65036f726700" +
+				"0000000400010004" + "000100090268320568332d3139" + "00040004c0000201"},
+		// escaped commas and backslashes in the comma separated form
+		{16, "foo.example.org.", map[string]interface{}{"alpn": `f\\oo\,bar,h2`},
+			"0010" + "03666f6f076578616d706c65036f726700" + "0001000c08665c6f6f2c626172026832"},
+		{16, "foo.example.org.", map[string]interface{}{"alpn": []interface{}{`f\oo,bar`, "h2"}},
+			"0010" + "03666f6f076578616d706c65036f726700" + "0001000c08665c6f6f2c626172026832"},
+	}
+
+	h := dns.RR_Header{Name: "example.com.", Rrtype: TypeSVCB, Class: dns.ClassINET}
+
+	for _, t := range tests {
+		rr, err := newSVCB(h, t.priority, t.target, t.params)
+		c.Assert(err, IsNil)
+		c.Check(rr.(*dns.RFC3597).Rdata, Equals, t.wire)
+		c.Check(rr.Header().Rrtype, Equals, TypeSVCB)
+	}
+}
+
+func (s *SVCBSuite) TestInvalid(c *C) {
+	h := dns.RR_Header{Name: "example.com.", Rrtype: TypeHTTPS, Class: dns.ClassINET}
+
+	bad := []map[string]interface{}{
+		{"alpn": "h2"}, // with priority 0 below
+		{"foo": "bar"},
+		{"ipv4hint": []interface{}{"2001:db8::1"}},
+		{"ipv6hint": "192.0.2.1"},
+		{"mandatory": "mandatory"},
+		{"ech": "not base64!"},
+		{"port": float64(443), "key3": float64(443)},
+		{"mandatory": "alpn,alpn", "alpn": "h2"},
+		{"mandatory": []interface{}{"alpn", "port"}, "alpn": "h2"},
+		{"mandatory": []interface{}{}},
+		{"alpn": []interface{}{}},
+		{"alpn": ""},
+		{"ipv4hint": []interface{}{}},
+		{"ipv6hint": ""},
+		{"no-default-alpn": true},
+		{"key65": `\x4`},
+		{"key65": `\n`},
+	}
+
+	for i, params := range bad {
+		priority := uint16(1)
+		if i == 0 {
+			priority = 0
+		}
+		_, err := newSVCB(h, priority, ".", params)
+		c.Check(err, NotNil)
+	}
+
+	_, err := newSVCB(h, 1, ".", map[string]interface{}{"port": float64(443), "key3": float64(443)})
+	c.Check(err, ErrorMatches, "duplicate SvcParamKey port")
+	_, err = newSVCB(h, 1, ".", map[string]interface{}{"mandatory": "port", "alpn": "h2"})
+	c.Check(err, ErrorMatches, "mandatory port is missing")
+	_, err = newSVCB(h, 1, ".", map[string]interface{}{"alpn": []interface{}{}})
+	c.Check(err, ErrorMatches, "invalid alpn: at least one id is needed")
+	_, err = newSVCB(h, 1, ".", map[string]interface{}{"no-default-alpn": true})
+	c.Check(err, ErrorMatches, "invalid no-default-alpn: alpn is needed too")
+}
+
+func (s *SVCBSuite) TestData(c *C) {
+	h := dns.RR_Header{Name: "example.com.", Rrtype: TypeHTTPS, Class: dns.ClassINET}
+
+	// the zone data from a record gives the same record, binary values
+	// of unknown keys are escaped
+	params := map[string]interface{}{
+		"alpn":            []interface{}{"h2", "h3,x"},
+		"no-default-alpn": true,
+		"port":            float64(8443),
+		"key65":           `a\x00\xff\x5c`,
+	}
+	rr, err := newSVCB(h, 1, "svc.example.com.", params)
+	c.Assert(err, IsNil)
+	c.Check(rr.(*dns.RFC3597).Rdata, Matches, ".*"+"00410004"+"6100ff5c")
+
+	data, err := svcbData(rr.(*dns.RFC3597))
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, map[string]interface{}{
+		"priority": float64(1),
+		"target":   "svc.example.com.",
+		"params":   params,
+	})
+	rr2, err := newSVCB(h, 1, "svc.example.com.", data["params"].(map[string]interface{}))
+	c.Assert(err, IsNil)
+	c.Check(rr2.(*dns.RFC3597).Rdata, Equals, rr.(*dns.RFC3597).Rdata)
+}
+
+func (s *ConfigSuite) TestSVCBRecords(c *C) {
+	tz := s.zones["test.example.com"]
+
+	label, qtype := tz.findLabels("", []string{"@"}, qTypes{TypeHTTPS})
+	c.Check(qtype, Equals, TypeHTTPS)
+	Https := label.Records[TypeHTTPS]
+	c.Assert(Https, HasLen, 1)
+	c.Check(Https[0].RR.Header().Rrtype, Equals, TypeHTTPS)
+	c.Check(Https[0].RR.(*dns.RFC3597).Rdata, Equals,
+		"0001"+"00"+"00010006026832026833"+"00040004c0a80102")
+
+	// targeted HTTPS record
+	label, qtype = tz.findLabels("", []string{"dk", "europe", "@"}, qTypes{TypeHTTPS})
+	c.Check(qtype, Equals, TypeHTTPS)
+	Https = label.Records[TypeHTTPS]
+	c.Assert(Https, HasLen, 1)
+	c.Check(Https[0].RR.(*dns.RFC3597).Rdata, Equals,
+		"0001"+"00"+"00010003026832"+"00040004c0a80103")
+
+	// relative targets get the zone name appended and weights work
+	label, qtype = tz.findLabels("_8443._api", []string{"@"}, qTypes{TypeSVCB})
+	c.Check(qtype, Equals, TypeSVCB)
+	Svcb := label.Records[TypeSVCB]
+	c.Assert(Svcb, HasLen, 2)
+	c.Check(label.Picker(TypeSVCB, 1), HasLen, 1)
+	rdata, err := hex.DecodeString(Svcb[0].RR.(*dns.RFC3597).Rdata)
+	c.Assert(err, IsNil)
+	target, _, err := dns.UnpackDomainName(rdata, 2)
+	c.Assert(err, IsNil)
+	c.Check(target, Equals, "foo.test.example.com.")
+
+	// problems with the parameters have their path
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fileName := dir + "/svcb.example.com.json"
+	data := `{ "data": { "": { "ns": [ "ns1.example.net." ] }, "svc": { "https": [ { "params": { "no-default-alpn": true } } ] } } }`
+	c.Assert(ioutil.WriteFile(fileName, []byte(data), 0644), IsNil)
+	zone, err := readZoneFile("svcb.example.com", fileName)
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, `error: data.svc.https\[0\].params.no-default-alpn: Bad HTTPS record: invalid no-default-alpn: alpn is needed too`)
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"strings"
+
+	"github.com/abh/geodns/countries"
 )
 
 type TargetOptions int
@@ -97,6 +99,54 @@ func (t TargetOptions) GetTargets(ip net.IP) ([]string, int) {
 	return targets, netmask
 }
 
+// targetedLabel returns the name a targeted label is for ("www" for
+// "www.europe" and "" for "europe"), if the last part of the label is one
+// of the targets used with the options.
+func (t TargetOptions) targetedLabel(label string) (string, bool) {
+	var base, target string
+	if strings.HasSuffix(label, "]") {
+		// IP targets have dots in them
+		i := strings.LastIndex(label, "[")
+		if i < 0 || t&TargetIP == 0 {
+			return "", false
+		}
+		base, target = label[:i], label[i:]
+	} else {
+		i := strings.LastIndex(label, ".")
+		base, target = label[:i+1], label[i+1:]
+	}
+	if len(base) > 0 {
+		if !strings.HasSuffix(base, ".") {
+			return "", false
+		}
+		base = base[:len(base)-1]
+	}
+
+	switch {
+	case strings.HasPrefix(target, "["):
+		return base, true
+	case t&TargetContinent > 0 && len(countries.ContinentCountries[target]) > 0,
+		t&TargetCountry > 0 && len(countries.CountryContinent[target]) > 0,
+		t&TargetRegionGroup > 0 && len(countries.RegionGroupRegions[target]) > 0,
+		t&TargetRegion > 0 && len(target) > 3 && target[2] == '-' && len(countries.CountryContinent[target[:2]]) > 0,
+		t&TargetASN > 0 && isASN(target):
+		return base, true
+	}
+	return "", false
+}
+
+func isASN(s string) bool {
+	if len(s) < 3 || !strings.HasPrefix(s, "as") {
+		return false
+	}
+	for _, c := range s[2:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func (t TargetOptions) String() string {
 	targets := make([]string, 0)
 	if t&TargetGlobal > 0 {
@@ -69,3 +69,40 @@ func (s *TargetingSuite) TestGetTargets(c *C) {
 	c.Check(targets, DeepEquals, []string{"[2607:f238:2::ff:4]", "[2607:f238:2::]"})
 
 }
+
+func (s *TargetingSuite) TestTargetedLabel(c *C) {
+	tgt, err := parseTargets("@ continent country regiongroup region asn ip")
+	c.Assert(err, IsNil)
+
+	for label, base := range map[string]string{
+		"www.europe":          "www",
+		"europe":              "",
+		"mx1.dk":              "mx1",
+		"www.us-west":         "www",
+		"www.us-ca":           "www",
+		"bar.as15169":         "bar",
+		"bar.[1.0.0.255]":     "bar",
+		"[2001:db8::1]":       "",
+		"a.b.[192.0.2.0]":     "a.b",
+		"www":                 "-",
+		"www.example":         "-",
+		"bar.asn":             "-",
+		"bar[192.0.2.1]":      "-",
+		"www.europe.internal": "-",
+	} {
+		name, ok := tgt.targetedLabel(label)
+		if base == "-" {
+			c.Check(ok, Equals, false, Commentf("%s", label))
+			continue
+		}
+		c.Check(ok, Equals, true, Commentf("%s", label))
+		c.Check(name, Equals, base, Commentf("%s", label))
+	}
+
+	// only the targets the zone uses
+	tgt, _ = parseTargets("@ continent")
+	_, ok := tgt.targetedLabel("www.dk")
+	c.Check(ok, Equals, false)
+	_, ok = tgt.targetedLabel("www.[192.0.2.1]")
+	c.Check(ok, Equals, false)
+}
@@ -210,6 +210,43 @@ eT/et5IedHenvnlqm/WHMYv8v4zgvwAAAP//sJ1SyW0MAAA=
 `,
 	},
 
+	"/zone.schema.json": {
+		local:   "zone.schema.json",
+		size:    11984,
+		modtime: 1792297875,
+		compressed: `
+H4sIAAAAAAAC/+RaW2/bOBZ+z68g1Dy0qF3HaZJm81IUu+higcW0aAvMQ+AGx9KRxFYiNYe0I0+R/z6g
+LrYk6x47M50x0CYR+ZHfufLwyD9OGLNOle1jCNYNs3yto5vZ7JuSYpo+fSXJmzkErp6evZmlz55ZkwTH
+nRyjbmYzj2t/tXxly3AGS3/moXSEmv0uBb7KljLLplDNdYAG/F+U//nlMzOzspFNlAzI5Te0dfoMHIdr
+LgUEH0lGSJqjsm6YC4HCZEJUfGxkYsySxD0uzN+7RZUmLjxrwiwHlU08MquaAQcjQhs0OhOmfUz4MAEh
+Mq7YSqFjsYdJuq5C4hCk654Sugb+bOagy0VCUs240OghVSF3oXSwiQ+KVWjdsFtmhZqHaB75oPyEKmi0
+2GK7mtbDdg8hvvOl0moQypZCg61r+W4nRcRDoM2dUO3zCF1C5bcRMFIV5mva9J6NccQJe08PueDhKuw9
+X0m469B5ab4G8lAb+Tt0skZSrbRTiCosTSCUi7T18vqAYaxH0GQG3AucFBwE8n4INfOxhNTc3QyGacW9
+waA1x54EM0z6M9dkID0vM9FRFak0aB90G9OllAGCKIuX4e4g4g1uVCuWAxoeIVMjxwCWGFiVzTSGUQAa
+1fF3PMl2tQpTtrtaefIq0sgPGBAsH95xEZsPZsvb7AErKriaCSvDu5QdgdZIyfHxdfr29mz6r8XL051h
+FiVdrbjQ1x0E2fOz6fnl5YuBRCfFlHY2SRJ+9tf55eUgMXoIMb/qIcXV5eXrQ8qRrHdISbJ8vi8G+/Ll
+/0wS+/zhHTMHMeOCKbSlcFRinvnFm4vr11cXbybs0/t/s/P59fyQ9touf0hh8wRTL3A+2k+IumRVGBar
+cNkndnbljqZVUu3MzX9JTjW/nJn/kqKn3nwY6wZx8j0q+ei2uHvOsrxofmK0rcskMWABV5pJl+WIfqqr
+FACVUSCCjSHHNYZN5VSDOtLxX5F7frtaJux2+9t9Mp0tjEjVpxOmdcAWjxBs+xvbE7E4lAt7W79er3q1
+qyxji9KWu4Pof9nmpZM8LxHzwfn2ebfuR/hOi/YZoS3JOZ57tRVOmTM1upyOuzytVlQQLC0M2D3XPtOx
+ZiCcTO6njKMOV61UL73rwLZasKi3IaVun0tf8QKSf6z7bULojqIC8qHL4yP3AKZXkfsPM32mt5/Z9GF8
+sAsT4W8rTuhkTY/Y2qXpJi1m27e6gxURukgo7NabdVbJlpAD9TxGx+X7k6L1kdSZtiD6qDSb2a1WSXqo
+QiPikrjeDMUpWt91K/cvYUIBkT5+N0aSg9TGsl77YwPBDcBrLRGS8rtsMqQ1t3EYiNDDOBqKiQKwMUTR
+w2mf3B1WxH+eiB4ZnSviP0t02gBHM4fXxxYmkrq0dF1REnjdtltDsBoYbE+ufB2oY2nfNrNcbqcvRjqt
+sFLg4UAzKAzQ1pIGwkLQts+Fd5dJOwRblCpBbitrH2OWdHYnXZ2g56YVBFP33fT94sf5w4uXp+U9nr7G
+Ub4bHckNXC48pIi46JUYIfBMwvPDoRE53JRFZn8fU67t5dFLnbGnUu9TDwjC8p5PcfcLQTigJW1G3QAh
+iMQooJBTB11YBXqar1GaULxvl9u4QdDeWtv1gbMrYv6pUhhxcTAfi0frC5+LVmyj3DxaX40Go+23v3Lb
+EzOP248tHvD1O27yPn1x9dtM25PaBnXT7fzPzAPpm7ljJ4KB33IY/00H87E8FEjZwVtKDLvmFoHwkAX8
+O7L5dH52xiQx877uYnZeThjVtF7jKIW3NtP0x/NZ+vPF21Or3szZy9ahLm1hrFE4fV617jnJIfLkkAZU
+kQH07VFXxAWAsdDsKj8CaZuvCo0l/BhswEF1H3pCtfh13qo1gZN85amuW1sYBMW+40ZVjL/Xte3u3D62
+e1vB5z7Z5omNOddk2lKuXdQ6ZRi3GSqMP2VvbEqg8quS/UO3fjGdXuDGvcNJwaWzuXS5KXXw+zIyqNGM
+UnAzI1q3omldq9pt+60JmEyohWatmsayhHgtzG7PLjZALSy/DDcaLFD1wO39qVE1ZkI9NKvXG5Fre1kL
+TL7Q2R9ZWyTsYmHnaH2cLIzH+1gYF12s0GUfR8X45Hh/p3UdmZI/DqOTQMcTyuF7lAqePoyQiaDRdFLw
+HplC/AwjY+JyNJkUvEemGJTD2BjkI7J3oGr5lEJ9oC8b6CO8OYPvUypkgoGMTHYaTyhF7/icmH8PJ38M
+AJsbNWTQLgAA
+`,
+	},
+
 	"/": {
 		isDir: true,
 		local: "/",
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+
+	"github.com/abh/errorutil"
+	"gopkg.in/yaml.v2"
+)
+
+// yamlValue decodes YAML into the same types the JSON decoder gives us
+// (map[string]interface{}, []interface{}, float64, string, bool and nil), so
+// YAML zones can use the same setup code as JSON zones.
+//
+// Map keys are decoded as strings directly so labels like "no", "on" or
+// "010" are kept as written rather than turned into booleans or numbers.
+type yamlValue struct {
+	v interface{}
+}
+
+func (y *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var m map[string]yamlValue
+	if err := unmarshal(&m); err == nil && m != nil {
+		obj := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			obj[k] = v.v
+		}
+		y.v = obj
+		return nil
+	}
+
+	var l []yamlValue
+	if err := unmarshal(&l); err == nil && l != nil {
+		list := make([]interface{}, len(l))
+		for i, v := range l {
+			list[i] = v.v
+		}
+		y.v = list
+		return nil
+	}
+
+	var s interface{}
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch s := s.(type) {
+	case int:
+		y.v = float64(s)
+	case int64:
+		y.v = float64(s)
+	case uint64:
+		y.v = float64(s)
+	case float64, string, bool, nil:
+		y.v = s
+	default:
+		return fmt.Errorf("unsupported value '%v'", s)
+	}
+	return nil
+}
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+):`)
+
+// readZoneYAML reads a YAML zone file, which has the same structure as the
+// JSON zone files.
+func readZoneYAML(fileName string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var zone yamlValue
+	if err = yaml.Unmarshal(data, &zone); err != nil {
+		extra := ""
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			offset := yamlLineEnd(data, line)
+			_, _, highlight := errorutil.HighlightBytePosition(bytes.NewReader(data), offset)
+			extra = fmt.Sprintf(":\nError at line %d:\n%s", line, highlight)
+		}
+		return nil, fmt.Errorf("error parsing YAML in config file %s%s\n%v",
+			fileName, extra, err)
+	}
+
+	switch objmap := zone.v.(type) {
+	case map[string]interface{}:
+		return objmap, nil
+	case nil:
+		return map[string]interface{}{}, nil
+	}
+	return nil, fmt.Errorf("config file %s isn't a YAML mapping", fileName)
+}
+
+// yamlLineEnd returns the offset of the end of line (counting from 1) in data.
+func yamlLineEnd(data []byte, line int) int64 {
+	offset := 0
+	for i := 1; i <= line; i++ {
+		n := bytes.IndexByte(data[offset:], '\n')
+		if n < 0 {
+			return int64(len(data))
+		}
+		if i == line {
+			return int64(offset + n)
+		}
+		offset += n + 1
+	}
+	return int64(offset)
+}
@@ -16,6 +16,17 @@ type ZoneOptions struct {
 	MaxHosts  int
 	Contact   string
 	Targeting TargetOptions
+	Reverse   []string
+	Transfer  *ZoneTransfer
+
+	// SOA record fields; the primary NS defaults to the first NS record
+	// and the SOA TTL (when 0) is derived from the zone TTL
+	PrimaryNs string
+	Refresh   int
+	Retry     int
+	Expire    int
+	Minimum   int
+	SoaTtl    int
 }
 
 type ZoneLogging struct {
@@ -26,6 +37,8 @@ type ZoneLogging struct {
 type Record struct {
 	RR     dns.RR
 	Weight int
+	// Ttl is the TTL set for just this record, 0 if it uses the label TTL
+	Ttl int
 }
 
 type Records []Record
@@ -71,6 +84,10 @@ type Zone struct {
 	Logging    *ZoneLogging
 	Metrics    ZoneMetrics
 
+	// TransferHistory has the changes from the previous versions of the
+	// zone, for IXFR
+	TransferHistory []*ZoneDiff
+
 	sync.RWMutex
 }
 
@@ -86,6 +103,10 @@ func NewZone(name string) *Zone {
 	zone.Options.Ttl = 120
 	zone.Options.MaxHosts = 2
 	zone.Options.Contact = "hostmaster." + name
+	zone.Options.Refresh = 5400
+	zone.Options.Retry = 5400
+	zone.Options.Expire = 1209600
+	zone.Options.Minimum = 3600
 	zone.Options.Targeting = TargetGlobal + TargetCountry + TargetContinent
 
 	return zone
@@ -127,6 +148,20 @@ func (z *Zone) Close() {
 	}
 }
 
+// setTtls sets the TTL of the records to their own TTL or the label TTL.
+func (l *Label) setTtls() {
+	for _, records := range l.Records {
+		for _, r := range records {
+			switch {
+			case r.Ttl > 0:
+				r.RR.Header().Ttl = uint32(r.Ttl)
+			case l.Ttl > 0:
+				r.RR.Header().Ttl = uint32(l.Ttl)
+			}
+		}
+	}
+}
+
 func (l *Label) firstRR(dnsType uint16) dns.RR {
 	return l.Records[dnsType][0].RR
 }
@@ -151,15 +186,131 @@ func (z *Zone) AddLabel(k string) *Label {
 	return label
 }
 
+// LabelName returns the label for name (relative to the zone origin) and
+// whether the name is in the zone at all.
+func (z *Zone) LabelName(name string) (string, bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+	origin := strings.ToLower(z.Origin) + "."
+	if name == origin {
+		return "", true
+	}
+	if strings.HasSuffix(name, "."+origin) {
+		return strings.TrimSuffix(name, "."+origin), true
+	}
+	return "", false
+}
+
+// AdditionalRecords returns the A and AAAA records for the targets of the
+// NS, MX and SRV records in rrs that are in the zone, picked with the same
+// targeting as the answer.
+func (z *Zone) AdditionalRecords(rrs []dns.RR, targets []string) []dns.RR {
+	var extra []dns.RR
+	seen := map[string]bool{}
+
+	for _, rr := range rrs {
+		var name string
+		switch rr := rr.(type) {
+		case *dns.NS:
+			name = rr.Ns
+		case *dns.MX:
+			name = rr.Mx
+		case *dns.SRV:
+			name = rr.Target
+		default:
+			continue
+		}
+
+		s, ok := z.LabelName(name)
+		if !ok || seen[s] {
+			continue
+		}
+		seen[s] = true
+
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			label, _ := z.findLabels(s, targets, qTypes{dns.TypeMF, qtype})
+			if label == nil {
+				break
+			}
+			for _, record := range label.Picker(qtype, label.MaxHosts) {
+				rr := dns.Copy(record.RR)
+				rr.Header().Name = dns.Fqdn(name)
+				extra = append(extra, rr)
+			}
+		}
+	}
+
+	return extra
+}
+
+// FindDelegation returns the closest label at or above s (but below the
+// zone apex) that has NS records, meaning the name is delegated to other
+// nameservers.
+func (z *Zone) FindDelegation(s string) (string, bool) {
+	for len(s) > 0 {
+		if label, ok := z.Labels[s]; ok && len(label.Records[dns.TypeNS]) > 0 {
+			return s, true
+		}
+		i := strings.Index(s, ".")
+		if i < 0 {
+			break
+		}
+		s = s[i+1:]
+	}
+	return "", false
+}
+
+// findAliasLoop returns a chain of aliases that loops back on itself
+// (for example "a", "b", "a"), or nil if there are no loops. Only the
+// labels themselves are checked, loops through targeted labels (like
+// "b.europe" aliased to "a") are caught by the depth limit in findLabels.
+func (z *Zone) findAliasLoop() []string {
+	names := make([]string, 0, len(z.Labels))
+	for name := range z.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		chain := []string{name}
+		seen := map[string]int{name: 0}
+		for {
+			label := z.Labels[name]
+			if label == nil || len(label.Records[dns.TypeMF]) == 0 {
+				break
+			}
+			name = strings.ToLower(label.firstRR(dns.TypeMF).(*dns.MF).Mf)
+			if i, ok := seen[name]; ok {
+				return append(chain[i:], name)
+			}
+			seen[name] = len(chain)
+			chain = append(chain, name)
+		}
+	}
+
+	return nil
+}
+
 func (z *Zone) SoaRR() dns.RR {
 	return z.Labels[""].firstRR(dns.TypeSOA)
 }
 
+// maxAliasDepth is how many aliases are followed when looking up a label
+const maxAliasDepth = 10
+
 // Find label "s" in country "cc" falling back to the appropriate
 // continent and the global label name as needed. Looks for the
 // first available qType at each targeting level. Return a Label
-// and the qtype that was "found"
+// and the qtype that was "found". If following aliases went deeper
+// than maxAliasDepth the label is nil and the qtype is dns.TypeMF.
 func (z *Zone) findLabels(s string, targets []string, qts qTypes) (*Label, uint16) {
+	return z.findLabelsDepth(s, targets, qts, 0)
+}
+
+func (z *Zone) findLabelsDepth(s string, targets []string, qts qTypes, depth int) (*Label, uint16) {
+	if depth > maxAliasDepth {
+		return nil, dns.TypeMF
+	}
+
 	for _, target := range targets {
 		var name string
 
@@ -186,8 +337,7 @@ func (z *Zone) findLabels(s string, targets []string, qts qTypes) (*Label, uint1
 				case dns.TypeMF:
 					if label.Records[dns.TypeMF] != nil {
 						name = label.firstRR(dns.TypeMF).(*dns.MF).Mf
-						// TODO: need to avoid loops here somehow
-						return z.findLabels(name, targets, qts)
+						return z.findLabelsDepth(name, targets, qts, depth+1)
 					}
 				default:
 					// return the label if it has the right record
@@ -229,8 +379,7 @@ func (z *Zone) findLabels(s string, targets []string, qts qTypes) (*Label, uint1
 					case dns.TypeMF:
 						if label.Records[dns.TypeMF] != nil {
 							name = label.firstRR(dns.TypeMF).(*dns.MF).Mf
-							// TODO: need to avoid loops here somehow
-							return z.findLabels(name, targets, qts)
+							return z.findLabelsDepth(name, targets, qts, depth+1)
 						}
 					default:
 						// return the label if it has the right record
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/miekg/dns"
 	. "gopkg.in/check.v1"
 )
@@ -111,3 +113,79 @@ func (s *ConfigSuite) TestExampleOrgZone(c *C) {
 	c.Check(Ns[1].RR.(*dns.NS).Ns, Equals, "ns2.example.com.")
 
 }
+
+func (s *ConfigSuite) TestAdditionalRecords(c *C) {
+	ex := s.zones["test.example.com"]
+
+	label, _ := ex.findLabels("mail", []string{"@"}, qTypes{dns.TypeMX})
+	mx := label.Picker(dns.TypeMX, label.MaxHosts)
+	c.Assert(mx, HasLen, 1)
+	rrs := []dns.RR{mx[0].RR}
+
+	extra := ex.AdditionalRecords(rrs, []string{"@"})
+	c.Assert(extra, HasLen, 2)
+	c.Check(extra[0].(*dns.A).A.String(), Equals, "192.168.2.1")
+	c.Check(extra[0].Header().Name, Equals, "mx1.test.example.com.")
+	c.Check(extra[1].(*dns.AAAA).AAAA.String(), Equals, "fd06:c1d3:e902::25")
+
+	// the additional records use the same targeting as the answer
+	extra = ex.AdditionalRecords(rrs, []string{"dk", "europe", "@"})
+	c.Assert(extra, HasLen, 2)
+	c.Check(extra[0].(*dns.A).A.String(), Equals, "192.168.2.2")
+
+	label, _ = ex.findLabels("_xmpp._tcp", []string{"@"}, qTypes{dns.TypeSRV})
+	c.Check(label.firstRR(dns.TypeSRV).(*dns.SRV).Target, Equals, "mx1.test.example.com.")
+	extra = ex.AdditionalRecords([]dns.RR{label.firstRR(dns.TypeSRV)}, []string{"@"})
+	c.Check(extra, HasLen, 2)
+
+	// targets outside the zone don't get additional records
+	label, _ = ex.findLabels("", []string{"@"}, qTypes{dns.TypeMX})
+	c.Check(ex.AdditionalRecords([]dns.RR{label.firstRR(dns.TypeMX)}, []string{"@"}), HasLen, 0)
+
+	name, ok := ex.LabelName("Foo.Test.Example.COM.")
+	c.Check(ok, Equals, true)
+	c.Check(name, Equals, "foo")
+	_, ok = ex.LabelName("foo.example.com.")
+	c.Check(ok, Equals, false)
+}
+
+func (s *ConfigSuite) TestFindDelegation(c *C) {
+	ex := s.zones["test.example.org"]
+
+	for _, name := range []string{"glue", "ns1.glue", "a.b.glue", "sub", "x.sub"} {
+		cut, ok := ex.FindDelegation(name)
+		c.Check(ok, Equals, true)
+		labels := strings.Split(name, ".")
+		c.Check(cut, Equals, labels[len(labels)-1])
+	}
+
+	for _, name := range []string{"", "bar", "sub-alias", "glue-not"} {
+		_, ok := ex.FindDelegation(name)
+		c.Check(ok, Equals, false, Commentf("name: %s", name))
+	}
+}
+
+func (s *ConfigSuite) TestAliasLoops(c *C) {
+	ex := s.zones["test.example.com"]
+
+	label, qtype := ex.findLabels("alias-loop", []string{"@"}, qTypes{dns.TypeMF, dns.TypeA})
+	c.Assert(label, NotNil)
+	c.Check(qtype, Equals, dns.TypeA)
+	c.Check(label.Label, Equals, "alias-loop-b")
+
+	// the targeted label loops back, so the lookup gives up
+	label, qtype = ex.findLabels("alias-loop", []string{"[192.0.2.1]", "@"}, qTypes{dns.TypeMF, dns.TypeA})
+	c.Check(label, IsNil)
+	c.Check(qtype, Equals, dns.TypeMF)
+
+	zone := NewZone("loop.example.com")
+	zone.AddLabel("c").Records[dns.TypeMF] = Records{Record{RR: &dns.MF{Mf: "a"}}}
+	zone.AddLabel("a").Records[dns.TypeMF] = Records{Record{RR: &dns.MF{Mf: "b"}}}
+	c.Check(zone.findAliasLoop(), IsNil)
+
+	zone.AddLabel("b").Records[dns.TypeMF] = Records{Record{RR: &dns.MF{Mf: "a"}}}
+	c.Check(zone.findAliasLoop(), DeepEquals, []string{"a", "b", "a"})
+
+	zone.Labels["b"].Records[dns.TypeMF][0].RR.(*dns.MF).Mf = "b"
+	c.Check(zone.findAliasLoop(), DeepEquals, []string{"b", "b"})
+}
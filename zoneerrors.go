@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ZoneErrorSeverity int
+
+const (
+	// SeverityWarning is for problems that don't stop the zone from
+	// loading, like unsupported record types (they are skipped).
+	SeverityWarning ZoneErrorSeverity = iota
+	// SeverityError problems stop the zone from loading.
+	SeverityError
+)
+
+func (s ZoneErrorSeverity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// ZoneError is a problem found when reading a zone. The path is the
+// location in the zone data, for example `data.foo.a[2]`.
+type ZoneError struct {
+	Path     string
+	Severity ZoneErrorSeverity
+	Message  string
+}
+
+func (e *ZoneError) Error() string {
+	if len(e.Path) == 0 {
+		return e.Severity.String() + ": " + e.Message
+	}
+	return e.Severity.String() + ": " + e.Path + ": " + e.Message
+}
+
+// ZoneErrors is the list of all problems found when reading a zone.
+type ZoneErrors []*ZoneError
+
+func (errs ZoneErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Error()
+	}
+	return fmt.Sprintf("%d problems:\n", len(errs)) + strings.Join(lines, "\n")
+}
+
+// Fatal returns true if there are any errors (rather than just warnings).
+func (errs ZoneErrors) Fatal() bool {
+	for _, e := range errs {
+		if e.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneLoader collects the problems found while setting up a zone from the
+// zone data.
+type zoneLoader struct {
+	errs ZoneErrors
+}
+
+func (l *zoneLoader) errorf(path, format string, a ...interface{}) {
+	l.errs = append(l.errs, &ZoneError{Path: path, Severity: SeverityError, Message: fmt.Sprintf(format, a...)})
+}
+
+func (l *zoneLoader) warnf(path, format string, a ...interface{}) {
+	l.errs = append(l.errs, &ZoneError{Path: path, Severity: SeverityWarning, Message: fmt.Sprintf(format, a...)})
+}
+
+// errors returns the number of errors (not warnings) so far, so callers can
+// check if something went wrong in between.
+func (l *zoneLoader) errors() int {
+	n := 0
+	for _, e := range l.errs {
+		if e.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
+
+func (l *zoneLoader) toInt(path string, v interface{}) (int, bool) {
+	i, err := valueToInt(v)
+	if err != nil {
+		l.errorf(path, "%s", err)
+		return 0, false
+	}
+	return i, true
+}
+
+// toIntRange is like toInt, checking that the value is between 0 and max.
+func (l *zoneLoader) toIntRange(path string, v interface{}, max int64) (int, bool) {
+	i, ok := l.toInt(path, v)
+	if !ok {
+		return 0, false
+	}
+	if i < 0 || int64(i) > max {
+		l.errorf(path, "%d is out of range (0-%d)", i, max)
+		return 0, false
+	}
+	return i, true
+}
+
+func (l *zoneLoader) toBool(path string, v interface{}) (bool, bool) {
+	b, err := valueToBool(v)
+	if err != nil {
+		l.errorf(path, "%s", err)
+		return false, false
+	}
+	return b, true
+}
+
+func (l *zoneLoader) toString(path string, v interface{}) (string, bool) {
+	s, err := valueToString(v)
+	if err != nil {
+		l.errorf(path, "%s", err)
+		return "", false
+	}
+	return s, true
+}
+
+func (l *zoneLoader) toObject(path string, v interface{}) (map[string]interface{}, bool) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		l.errorf(path, "expected an object, got %s", describeValue(v))
+	}
+	return obj, ok
+}
+
+// intField returns the value of key in rec (0 if it isn't set), checking
+// that it's between 0 and max.
+func (l *zoneLoader) intField(path string, rec map[string]interface{}, key string, max int) int {
+	v := rec[key]
+	if v == nil {
+		return 0
+	}
+	i, _ := l.toIntRange(pathKey(path, key), v, int64(max))
+	return i
+}
+
+// stringField returns the value of key in rec ("" if it isn't set).
+func (l *zoneLoader) stringField(path string, rec map[string]interface{}, key string) string {
+	v := rec[key]
+	if v == nil {
+		return ""
+	}
+	s, _ := l.toString(pathKey(path, key), v)
+	return s
+}
+
+// stringWeight reads the "value", [ "value", weight ] or
+// [ "value", weight, ttl ] record syntax.
+func (l *zoneLoader) stringWeight(path string, rec interface{}) (str string, weight, ttl int, ok bool) {
+	switch rec := rec.(type) {
+	case string:
+		return rec, 0, 0, true
+	case []interface{}:
+		if len(rec) == 0 || len(rec) > 3 {
+			break
+		}
+		str, ok = rec[0].(string)
+		if !ok {
+			break
+		}
+		if len(rec) > 1 {
+			if weight, ok = l.toIntRange(pathIndex(path, 1), rec[1], math.MaxInt32); !ok {
+				return "", 0, 0, false
+			}
+		}
+		if len(rec) > 2 {
+			if ttl, ok = l.toIntRange(pathIndex(path, 2), rec[2], math.MaxInt32); !ok {
+				return "", 0, 0, false
+			}
+		}
+		return str, weight, ttl, true
+	}
+	l.errorf(path, "expected a string, [ string, weight ] or [ string, weight, ttl ], got %s", describeValue(rec))
+	return "", 0, 0, false
+}
+
+func describeValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return strconv.Quote(v)
+	}
+	return fmt.Sprintf("'%v'", v)
+}
+
+var simplePathKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// pathKey returns the path for key in the object at path, using the
+// data["www.europe"] syntax for keys that aren't plain words.
+func pathKey(path, key string) string {
+	if !simplePathKey.MatchString(key) {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
+
+func pathIndex(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Labels with a "generate" range are expanded into a label for each number
+// in the range, like $GENERATE in BIND zone files:
+//
+//	"node-${0,3}": { "generate": "1-200", "a": [ "192.0.2.$" ] }
+//
+// creates node-001 to node-200 with A records 192.0.2.1 to 192.0.2.200. In
+// the label name and all strings in the label data, "$" is replaced with the
+// number and "${offset,width,base}" with the number plus the offset,
+// zero-padded to the width, in base d (decimal, the default), o (octal), x
+// or X (hexadecimal). Use "\$" for a literal "$".
+
+// the most labels that can be generated in a zone
+const maxGenerate = 10000
+
+func (l *zoneLoader) expandGenerate(data map[string]interface{}) {
+	total := 0
+
+	for _, dk := range sortedKeys(data) {
+		label, ok := data[dk].(map[string]interface{})
+		if !ok || label["generate"] == nil {
+			continue
+		}
+		delete(data, dk)
+
+		path := pathKey("data", dk)
+		rangePath := pathKey(path, "generate")
+
+		str, ok := l.toString(rangePath, label["generate"])
+		if !ok {
+			continue
+		}
+		start, stop, step, err := parseGenerateRange(str)
+		if err != nil {
+			l.errorf(rangePath, "%s", err)
+			continue
+		}
+		count := (stop-start)/step + 1
+		if total+count > maxGenerate {
+			l.errorf(rangePath, "Can't generate more than %d labels in a zone", maxGenerate)
+			continue
+		}
+		total += count
+
+		tmpl := make(map[string]interface{}, len(label))
+		for k, v := range label {
+			if k != "generate" {
+				tmpl[k] = v
+			}
+		}
+
+		for i := start; i <= stop; i += step {
+			name, err := generateString(dk, i)
+			if err != nil {
+				l.errorf(path, "%s", err)
+				break
+			}
+			if _, exists := data[name]; exists {
+				l.errorf(path, "Generated label '%s' already exists", name)
+				continue
+			}
+			v, err := generateValue(tmpl, i)
+			if err != nil {
+				l.errorf(path, "%s", err)
+				break
+			}
+			data[name] = v
+		}
+	}
+}
+
+// parseGenerateRange parses "start-stop" or "start-stop/step".
+func parseGenerateRange(s string) (start, stop, step int, err error) {
+	step = 1
+	r := s
+	if i := strings.Index(r, "/"); i >= 0 {
+		step, err = strconv.Atoi(r[i+1:])
+		if err != nil || step < 1 {
+			return 0, 0, 0, fmt.Errorf("Invalid step in range '%s'", s)
+		}
+		r = r[:i]
+	}
+	bounds := strings.SplitN(r, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, 0, fmt.Errorf("Invalid range '%s', expected start-stop or start-stop/step", s)
+	}
+	start, err1 := strconv.Atoi(bounds[0])
+	stop, err2 := strconv.Atoi(bounds[1])
+	if err1 != nil || err2 != nil || start < 0 || stop < start {
+		return 0, 0, 0, fmt.Errorf("Invalid range '%s', expected start-stop or start-stop/step", s)
+	}
+	return start, stop, step, nil
+}
+
+// generateValue returns a copy of v with the "$" substitutions for i made in
+// all strings (including object keys).
+func generateValue(v interface{}, i int) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return generateString(v, i)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for n, item := range v {
+			g, err := generateValue(item, i)
+			if err != nil {
+				return nil, err
+			}
+			list[n] = g
+		}
+		return list, nil
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			k, err := generateString(key, i)
+			if err != nil {
+				return nil, err
+			}
+			g, err := generateValue(item, i)
+			if err != nil {
+				return nil, err
+			}
+			obj[k] = g
+		}
+		return obj, nil
+	}
+	return v, nil
+}
+
+// generateString replaces "$" and "${offset,width,base}" in s with i.
+func generateString(s string, i int) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var b bytes.Buffer
+	for n := 0; n < len(s); n++ {
+		switch {
+		case s[n] == '\\' && n+1 < len(s) && s[n+1] == '$':
+			b.WriteByte('$')
+			n++
+		case s[n] == '$' && n+1 < len(s) && s[n+1] == '{':
+			end := strings.IndexByte(s[n:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("Missing '}' in '%s'", s)
+			}
+			str, err := generateModifier(s[n+2:n+end], i)
+			if err != nil {
+				return "", fmt.Errorf("Invalid modifier in '%s': %s", s, err)
+			}
+			b.WriteString(str)
+			n += end
+		case s[n] == '$':
+			b.WriteString(strconv.Itoa(i))
+		default:
+			b.WriteByte(s[n])
+		}
+	}
+	return b.String(), nil
+}
+
+func generateModifier(modifier string, i int) (string, error) {
+	fields := strings.Split(modifier, ",")
+	if len(fields) > 3 {
+		return "", fmt.Errorf("expected ${offset,width,base}")
+	}
+
+	offset, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid offset '%s'", fields[0])
+	}
+	width := 0
+	if len(fields) > 1 {
+		width, err = strconv.Atoi(fields[1])
+		if err != nil || width < 0 || width > 64 {
+			return "", fmt.Errorf("invalid width '%s'", fields[1])
+		}
+	}
+	base := "d"
+	if len(fields) > 2 {
+		base = fields[2]
+	}
+
+	switch base {
+	case "d", "o", "x", "X":
+		return fmt.Sprintf("%0*"+base, width, i+offset), nil
+	}
+	return "", fmt.Errorf("invalid base '%s'", base)
+}
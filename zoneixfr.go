@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Incremental zone transfers (IXFR, RFC 1995). When a zone with the
+// "transfer" option is reloaded the changes from the previous version are
+// added to the zone history, so a secondary with one of the last
+// transferHistory serials just gets the changes. Other secondaries get the
+// whole zone, like for an AXFR.
+
+// transferHistory is how many versions of a zone the changes are kept for
+const transferHistory = 10
+
+// ZoneDiff has the records deleted and added from one version of a zone to
+// the next.
+type ZoneDiff struct {
+	From    dns.RR // the SOA records
+	To      dns.RR
+	Deleted []dns.RR
+	Added   []dns.RR
+}
+
+// setupTransferHistory keeps the history of the zone being replaced (old)
+// and adds the changes from it.
+func (z *Zone) setupTransferHistory(old *Zone) {
+	if old == nil || z.Options.Transfer == nil || old.Options.Transfer == nil ||
+		strings.Join(z.Options.Transfer.View, " ") != strings.Join(old.Options.Transfer.View, " ") {
+		return
+	}
+
+	oldRRs := old.transferRecords(old.Options.Transfer.View)
+	newRRs := z.transferRecords(z.Options.Transfer.View)
+	deleted, added := diffRecords(oldRRs[1:len(oldRRs)-1], newRRs[1:len(newRRs)-1])
+	diff := &ZoneDiff{From: oldRRs[0], To: newRRs[0], Deleted: deleted, Added: added}
+
+	if diff.From.(*dns.SOA).Serial == diff.To.(*dns.SOA).Serial {
+		if len(deleted) == 0 && len(added) == 0 && diff.From.String() == diff.To.String() {
+			z.TransferHistory = old.TransferHistory
+			return
+		}
+		// the secondaries can't tell the versions apart
+		log.Printf("[zone %s] the zone changed, but not the serial (%d); IXFR history cleared",
+			z.Origin, z.Options.Serial)
+		return
+	}
+
+	history := make([]*ZoneDiff, 0, transferHistory)
+	if n := len(old.TransferHistory); n >= transferHistory {
+		history = append(history, old.TransferHistory[n-transferHistory+1:]...)
+	} else {
+		history = append(history, old.TransferHistory...)
+	}
+	z.TransferHistory = append(history, diff)
+}
+
+// diffRecords returns the records in a but not in b (deleted) and the ones
+// in b but not in a (added).
+func diffRecords(a, b []dns.RR) (deleted, added []dns.RR) {
+	missing := func(from, in []dns.RR) []dns.RR {
+		count := make(map[string]int, len(in))
+		for _, rr := range in {
+			count[rr.String()]++
+		}
+		var rrs []dns.RR
+		for _, rr := range from {
+			if s := rr.String(); count[s] > 0 {
+				count[s]--
+				continue
+			}
+			rrs = append(rrs, rr)
+		}
+		return rrs
+	}
+	return missing(a, b), missing(b, a)
+}
+
+// ixfrRecords returns the records for an IXFR request from a secondary with
+// the serial: just the SOA record if it's up to date, the changes since the
+// serial when they are in the history or else the whole zone.
+func (z *Zone) ixfrRecords(serial uint32) []dns.RR {
+	soa := z.SoaRR()
+	if soa.(*dns.SOA).Serial == serial {
+		return []dns.RR{soa}
+	}
+
+	// the same serial can be in the history more than once (if the
+	// zone went back to an old version), the latest is the one
+	for i := len(z.TransferHistory) - 1; i >= 0; i-- {
+		if z.TransferHistory[i].From.(*dns.SOA).Serial != serial {
+			continue
+		}
+		rrs := []dns.RR{soa}
+		for _, diff := range z.TransferHistory[i:] {
+			rrs = append(rrs, diff.From)
+			rrs = append(rrs, diff.Deleted...)
+			rrs = append(rrs, diff.To)
+			rrs = append(rrs, diff.Added...)
+		}
+		return append(rrs, soa)
+	}
+
+	return z.transferRecords(z.Options.Transfer.View)
+}
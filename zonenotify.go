@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// notifyRetries is how many times a NOTIFY is sent to a server that doesn't
+// answer
+const notifyRetries = 3
+
+// notifyTimeout is how long to wait for the answer to a NOTIFY (doubled for
+// each retry)
+var notifyTimeout = 2 * time.Second
+
+// sendNotify sends a NOTIFY (RFC 1996) for the zone to the "notify" servers
+// in the background, so secondaries check the serial (and get the changes)
+// right away rather than when the SOA refresh timer runs out.
+func (srv *Server) sendNotify(z *Zone) {
+	t := z.Options.Transfer
+	if t == nil || len(t.Notify) == 0 || *flagcheckconfig {
+		return
+	}
+
+	// signed with the first of the zone TSIG keys we have the secret for
+	var key string
+	for _, name := range t.Keys {
+		if _, ok := srv.tsigSecrets[name]; ok {
+			key = name
+			break
+		}
+	}
+
+	soa := z.SoaRR()
+	for _, addr := range t.Notify {
+		go srv.notify(z.Origin, soa, addr, key, srv.tsigAlgorithm(key))
+	}
+}
+
+func (srv *Server) notify(origin string, soa dns.RR, addr, key, algorithm string) {
+	timeout := notifyTimeout
+	for i := 0; i < notifyRetries; i++ {
+		m := new(dns.Msg)
+		m.SetNotify(dns.Fqdn(origin))
+		m.Answer = []dns.RR{soa}
+
+		c := &dns.Client{DialTimeout: timeout, ReadTimeout: timeout, WriteTimeout: timeout}
+		if len(key) > 0 {
+			c.TsigSecret = srv.tsigSecrets
+			m.SetTsig(key, algorithm, 300, time.Now().Unix())
+		}
+
+		r, _, err := c.Exchange(m, addr)
+		if err == nil {
+			if r.Rcode != dns.RcodeSuccess {
+				log.Printf("[zone %s] NOTIFY to %s: %s", origin, addr, dns.RcodeToString[r.Rcode])
+			}
+			return
+		}
+		logPrintf("[zone %s] NOTIFY to %s failed: %s\n", origin, addr, err)
+		timeout *= 2
+	}
+	log.Printf("[zone %s] NOTIFY to %s failed %d times, giving up", origin, addr, notifyRetries)
+}
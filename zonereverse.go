@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// A reverse zone (in-addr.arpa or ip6.arpa) can get its PTR records from the
+// A and AAAA records in other zones with the "reverse" option:
+//
+//	"reverse": [ "example.com", "example.net" ]
+//
+// PTR records set in the reverse zone itself take precedence. When an
+// address is used by more than one name the PTR record points to the
+// shortest name, so "www" is used rather than targeted labels like
+// "www.europe". The reverse zone is regenerated when any of the zones it's
+// made from is reloaded. Only reverse zones from the zone directory can be
+// regenerated, the option is ignored for the other zone sources.
+
+func isReverseZone(zoneName string) bool {
+	zoneName = strings.ToLower(zoneName)
+	return strings.HasSuffix(zoneName, ".in-addr.arpa") || strings.HasSuffix(zoneName, ".ip6.arpa")
+}
+
+// setupReverseZones adds the PTR records to the reverse zones that were read
+// in this pass (in pending) and regenerates the ones made from a zone that
+// was reloaded or removed (in changed).
+func (srv *Server) setupReverseZones(zones Zones, pending Zones, changed map[string]bool, zoneFiles map[string][]string) {
+	names := make([]string, 0)
+	for name := range pending {
+		names = append(names, name)
+	}
+	for name, zone := range zones {
+		if _, ok := pending[name]; ok || len(zone.Options.Reverse) == 0 {
+			continue
+		}
+		for _, source := range zone.Options.Reverse {
+			if changed[source] {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	for _, zoneName := range names {
+		zone, ok := pending[zoneName]
+		if !ok {
+			// start over from the data in the reverse zone files
+			fileNames, ok := zoneFiles[zoneName]
+			if !ok {
+				continue
+			}
+			var err error
+			zone, err = readZoneFile(zoneName, fileNames...)
+			if zone == nil || err != nil {
+				log.Printf("Error reading zone '%s': %s", zoneName, err)
+				continue
+			}
+		}
+
+		sources := make([]*Zone, 0, len(zone.Options.Reverse))
+		for _, source := range zone.Options.Reverse {
+			src, ok := zones[source]
+			if !ok {
+				log.Printf("%s: reverse zone source '%s' is not loaded", zoneName, source)
+				continue
+			}
+			sources = append(sources, src)
+		}
+
+		addReverseRecords(zone, sources)
+		if err := setReverseSerial(zone, sources, zones[zoneName]); err != nil {
+			log.Printf("%s: %s", zoneName, err)
+			continue
+		}
+		srv.addHandler(zones, zoneName, zone)
+	}
+}
+
+// setReverseSerial sets the serial of the reverse zone to the highest serial
+// of the zone files and the source zones, so it changes when the PTR records
+// do. It's always higher than the serial of the version it replaces (old),
+// even when a source zone with a higher serial was removed.
+func setReverseSerial(zone *Zone, sources []*Zone, old *Zone) error {
+	serial := zone.Options.Serial
+	for _, src := range sources {
+		if src.Options.Serial > serial {
+			serial = src.Options.Serial
+		}
+	}
+	if old != nil && serial <= old.Options.Serial {
+		serial = old.Options.Serial + 1
+	}
+	if serial == zone.Options.Serial {
+		return nil
+	}
+	zone.Options.Serial = serial
+	return setupSOA(zone)
+}
+
+// addReverseRecords adds PTR records to the reverse zone for the addresses
+// in the A and AAAA records of the source zones. Targeted labels (like
+// "www.europe") aren't names in the zone, so they're skipped.
+func addReverseRecords(zone *Zone, sources []*Zone) {
+	targets := make(map[string]string)
+
+	for _, src := range sources {
+		src.RLock()
+		for labelName, label := range src.Labels {
+			if _, ok := src.Options.Targeting.targetedLabel(labelName); ok {
+				continue
+			}
+			for _, dnsType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				for _, r := range label.Records[dnsType] {
+					var ip net.IP
+					switch rr := r.RR.(type) {
+					case *dns.A:
+						ip = rr.A
+					case *dns.AAAA:
+						ip = rr.AAAA
+					}
+					addr, err := dns.ReverseAddr(ip.String())
+					if err != nil {
+						continue
+					}
+					name, ok := zone.LabelName(addr)
+					if !ok || len(name) == 0 {
+						continue
+					}
+					target := strings.ToLower(r.RR.Header().Name)
+					if current, ok := targets[name]; ok && !shorterName(target, current) {
+						continue
+					}
+					targets[name] = target
+				}
+			}
+		}
+		src.RUnlock()
+	}
+
+	for name, target := range targets {
+		label, ok := zone.Labels[name]
+		if ok && len(label.Records[dns.TypePTR]) > 0 {
+			continue
+		}
+		if !ok {
+			label = zone.AddLabel(name)
+		}
+		h := dns.RR_Header{
+			Name:   name + "." + zone.Origin + ".",
+			Rrtype: dns.TypePTR,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(label.Ttl),
+		}
+		label.Records[dns.TypePTR] = Records{{RR: &dns.PTR{Hdr: h, Ptr: target}}}
+
+		// the names in between (like "1" for "2.1") have to exist, too
+		subLabels := strings.Split(name, ".")
+		for i := 1; i < len(subLabels); i++ {
+			subSubLabel := strings.Join(subLabels[i:], ".")
+			if _, ok := zone.Labels[subSubLabel]; !ok {
+				zone.AddLabel(subSubLabel)
+			}
+		}
+	}
+}
+
+// shorterName returns true if a has fewer labels than b (or the same number
+// and sorts first).
+func shorterName(a, b string) bool {
+	na, nb := dns.CountLabel(a), dns.CountLabel(b)
+	if na != nb {
+		return na < nb
+	}
+	return a < b
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net"
 	"os"
 	"path"
@@ -24,47 +25,85 @@ import (
 type Zones map[string]*Zone
 
 type ZoneReadRecord struct {
-	time time.Time
-	hash string
+	time  time.Time
+	hash  string
+	files string
 }
 
 var lastRead = map[string]*ZoneReadRecord{}
 
+// zonesReadDir reads the zone directory and installs the new and changed
+// zones (and removes the zones whose files were removed) right away.
 func (srv *Server) zonesReadDir(dirName string, zones Zones) error {
+	updates, err := srv.dirZoneSource(dirName).read()
+	for _, u := range updates {
+		srv.installZone(zones, u)
+	}
+	return err
+}
+
+// read reads the new and changed zone files in the directory and returns
+// the updates, with the reverse zones last (so the zones they are made from
+// are installed first).
+func (s *dirZoneSource) read() ([]zoneUpdate, error) {
+	dirName := s.dir
 	dir, err := ioutil.ReadDir(dirName)
 	if err != nil {
 		log.Println("Could not read", dirName, ":", err)
-		return err
+		return nil, err
 	}
 
 	seenZones := map[string]bool{}
 
 	var parseErr error
 
+	// A zone can be read from a JSON (or YAML) file, a master file or both
+	// (the JSON file then adds options and targeted labels to the master
+	// file).
+	zoneFiles := map[string][]os.FileInfo{}
+	zoneNames := []string{}
+
+	var updates, reverseUpdates []zoneUpdate
+
 	for _, file := range dir {
 		fileName := file.Name()
-		if !strings.HasSuffix(strings.ToLower(fileName), ".json") ||
+		if !isZoneFile(fileName) ||
 			strings.HasPrefix(path.Base(fileName), ".") ||
 			file.IsDir() {
 			continue
 		}
 
 		zoneName := zoneNameFromFile(fileName)
+		if _, ok := zoneFiles[zoneName]; !ok {
+			zoneNames = append(zoneNames, zoneName)
+		}
+		zoneFiles[zoneName] = append(zoneFiles[zoneName], file)
+	}
+
+	for _, zoneName := range zoneNames {
+		files := zoneFiles[zoneName]
 
 		seenZones[zoneName] = true
 
-		if _, ok := lastRead[zoneName]; !ok || file.ModTime().After(lastRead[zoneName].time) {
-			modTime := file.ModTime()
+		var modTime time.Time
+		fileNames := make([]string, len(files))
+		for i, file := range files {
+			if file.ModTime().After(modTime) {
+				modTime = file.ModTime()
+			}
+			fileNames[i] = path.Join(dirName, file.Name())
+		}
+		fileList := strings.Join(fileNames, " ")
+
+		if rec, ok := lastRead[zoneName]; !ok || modTime.After(rec.time) || rec.files != fileList {
 			if ok {
-				logPrintf("Reloading %s\n", fileName)
+				logPrintf("Reloading %s\n", fileList)
 				lastRead[zoneName].time = modTime
 			} else {
-				logPrintf("Reading new file %s\n", fileName)
+				logPrintf("Reading new file %s\n", fileList)
 				lastRead[zoneName] = &ZoneReadRecord{time: modTime}
 			}
 
-			filename := path.Join(dirName, fileName)
-
 			// Check the sha256 of the file has not changed. It's worth an explanation of
 			// why there isn't a TOCTOU race here. Conceivably after checking whether the
 			// SHA has changed, the contents then change again before we actually load
@@ -86,13 +125,14 @@ func (srv *Server) zonesReadDir(dirName string, zones Zones) error {
 			// Provided files are replaced atomically, this should be OK. If files are not
 			// replaced atomically we have other problems (e.g. partial reads).
 
-			sha256 := sha256File(filename)
+			sha256 := sha256Files(fileNames)
 			if lastRead[zoneName].hash == sha256 {
-				logPrintf("Skipping new file %s as hash is unchanged\n", filename)
+				logPrintf("Skipping new file %s as hash is unchanged\n", fileList)
+				lastRead[zoneName].files = fileList
 				continue
 			}
 
-			config, err := readZoneFile(zoneName, filename)
+			config, err := readZoneFile(zoneName, fileNames...)
 			if config == nil || err != nil {
 				parseErr = fmt.Errorf("Error reading zone '%s': %s", zoneName, err)
 				log.Println(parseErr.Error())
@@ -100,26 +140,32 @@ func (srv *Server) zonesReadDir(dirName string, zones Zones) error {
 			}
 
 			(lastRead[zoneName]).hash = sha256
+			(lastRead[zoneName]).files = fileList
 
-			srv.addHandler(zones, zoneName, config)
+			s.zones[zoneName] = true
+			u := zoneUpdate{name: zoneName, zone: config, dir: dirName, files: fileNames}
+			if len(config.Options.Reverse) > 0 {
+				reverseUpdates = append(reverseUpdates, u)
+				continue
+			}
+			updates = append(updates, u)
 		}
 	}
 
-	for zoneName, zone := range zones {
-		if zoneName == "pgeodns" {
-			continue
-		}
-		if ok, _ := seenZones[zoneName]; ok {
-			continue
+	var removed []string
+	for zoneName := range s.zones {
+		if !seenZones[zoneName] {
+			removed = append(removed, zoneName)
 		}
-		log.Println("Removing zone", zone.Origin)
+	}
+	sort.Strings(removed)
+	for _, zoneName := range removed {
 		delete(lastRead, zoneName)
-		zone.Close()
-		dns.HandleRemove(zoneName)
-		delete(zones, zoneName)
+		delete(s.zones, zoneName)
+		updates = append(updates, zoneUpdate{name: zoneName, dir: dirName})
 	}
 
-	return parseErr
+	return append(updates, reverseUpdates...), parseErr
 }
 
 func (srv *Server) setupPgeodnsZone(zones Zones) {
@@ -129,7 +175,9 @@ func (srv *Server) setupPgeodnsZone(zones Zones) {
 	label.Records = make(map[uint16]Records)
 	label.Weight = make(map[uint16]int)
 	Zone.Labels[""] = label
-	setupSOA(Zone)
+	if err := setupSOA(Zone); err != nil {
+		log.Println(err)
+	}
 	srv.addHandler(zones, zoneName, Zone)
 }
 
@@ -141,7 +189,80 @@ func (srv *Server) setupRootZone() {
 	})
 }
 
-func readZoneFile(zoneName, fileName string) (zone *Zone, zerr error) {
+// readZoneFile reads a zone from a JSON or YAML zone file, an RFC 1035
+// master file (".zone") or both. Problems with the zone data are returned
+// as ZoneErrors (with all the errors and warnings); if there are only
+// warnings they are logged and the zone is returned.
+func readZoneFile(zoneName string, fileNames ...string) (*Zone, error) {
+	zone := NewZone(zoneName)
+
+	var objmap, masterData, masterOptions map[string]interface{}
+
+	for _, fileName := range fileNames {
+		fileInfo, err := os.Stat(fileName)
+		if err != nil {
+			log.Printf("Could not stat '%s': %s", fileName, err)
+		} else if serial := int(fileInfo.ModTime().Unix()); serial > zone.Options.Serial {
+			zone.Options.Serial = serial
+		}
+
+		ext := strings.ToLower(path.Ext(fileName))
+		if ext == ".zone" {
+			masterData, masterOptions, err = readMasterFile(zoneName, fileName)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing master file %s: %s", fileName, err)
+			}
+			continue
+		}
+
+		if objmap != nil {
+			return nil, fmt.Errorf("more than one JSON or YAML file for %s", zoneName)
+		}
+		switch ext {
+		case ".yaml", ".yml":
+			objmap, err = readZoneYAML(fileName)
+		default:
+			objmap, err = readZoneJSON(fileName)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if objmap == nil {
+		objmap = make(map[string]interface{})
+	}
+	if masterData != nil {
+		for k, v := range masterOptions {
+			if _, ok := objmap[k]; !ok {
+				objmap[k] = v
+			}
+		}
+		data, _ := objmap["data"].(map[string]interface{})
+		objmap["data"] = mergeZoneData(data, masterData)
+	}
+
+	return setupZone(zone, objmap)
+}
+
+// readZoneRecords sets up a zone from the records of a zone transfer (from
+// source), like the records of a master file.
+func readZoneRecords(zoneName, source string, rrs []dns.RR) (*Zone, error) {
+	data, objmap, err := masterRecords(zoneName, source, rrs)
+	if err != nil {
+		return nil, err
+	}
+	objmap["data"] = data
+	return setupZone(NewZone(zoneName), objmap)
+}
+
+// setupZone sets up the zone from the zone data (the objmap from the JSON or
+// YAML file with the master file data merged in).
+func setupZone(zone *Zone, objmap map[string]interface{}) (_ *Zone, zerr error) {
+	zoneName := zone.Origin
+
+	// problems with the zone data are returned as ZoneErrors; this is only
+	// so a bug in the loader doesn't take the server down
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("reading %s failed: %s", zoneName, r)
@@ -150,92 +271,156 @@ func readZoneFile(zoneName, fileName string) (zone *Zone, zerr error) {
 		}
 	}()
 
-	fh, err := os.Open(fileName)
-	if err != nil {
-		log.Printf("Could not read '%s': %s", fileName, err)
-		panic(err)
+	// the hash for the serial is of the data as written, before templates
+	// and generated labels are expanded
+	serialMode := serialModeMtime
+	var contentHash string
+	if objmap["serial_mode"] != nil {
+		contentHash = zoneContentHash(objmap)
 	}
 
-	zone = NewZone(zoneName)
-
-	fileInfo, err := fh.Stat()
-	if err != nil {
-		log.Printf("Could not stat '%s': %s", fileName, err)
-	} else {
-		zone.Options.Serial = int(fileInfo.ModTime().Unix())
-	}
+	l := new(zoneLoader)
+	var data, templates map[string]interface{}
 
-	var objmap map[string]interface{}
-	decoder := json.NewDecoder(fh)
-	if err = decoder.Decode(&objmap); err != nil {
-		extra := ""
-		if serr, ok := err.(*json.SyntaxError); ok {
-			if _, serr := fh.Seek(0, os.SEEK_SET); serr != nil {
-				log.Fatalf("seek error: %v", serr)
-			}
-			line, col, highlight := errorutil.HighlightBytePosition(fh, serr.Offset)
-			extra = fmt.Sprintf(":\nError at line %d, column %d (file offset %d):\n%s",
-				line, col, serr.Offset, highlight)
-		}
-		return nil, fmt.Errorf("error parsing JSON object in config file %s%s\n%v",
-			fh.Name(), extra, err)
+	// the labels are generated before the schema is checked, so "$" can be
+	// used in numbers (like "preference": "$")
+	if data, ok := objmap["data"].(map[string]interface{}); ok {
+		l.expandGenerate(data)
 	}
-
-	if err != nil {
-		panic(err)
+	if errs := validateZoneSchema(objmap); len(errs) > 0 {
+		return nil, append(l.errs, errs...)
 	}
-	//log.Println(objmap)
 
-	var data map[string]interface{}
-
-	for k, v := range objmap {
-		//log.Printf("k: %s v: %#v, T: %T\n", k, v, v)
+	for _, k := range sortedKeys(objmap) {
+		v := objmap[k]
 
 		switch k {
+		case "origin":
+			// older zone files have it, the zone name is used
+			l.warnf(k, "The origin option is deprecated and ignored")
 		case "ttl":
-			zone.Options.Ttl = valueToInt(v)
+			zone.Options.Ttl, _ = l.toIntRange(k, v, math.MaxInt32)
 		case "serial":
-			zone.Options.Serial = valueToInt(v)
+			zone.Options.Serial, _ = l.toIntRange(k, v, math.MaxUint32)
+		case "serial_mode":
+			str, ok := l.toString(k, v)
+			if !ok {
+				continue
+			}
+			switch str {
+			case serialModeMtime, serialModeHash, serialModeDate:
+				serialMode = str
+			default:
+				l.errorf(k, "Unknown serial mode '%s'", str)
+			}
 		case "contact":
-			zone.Options.Contact = v.(string)
+			zone.Options.Contact, _ = l.toString(k, v)
+		case "primary_ns":
+			zone.Options.PrimaryNs, _ = l.toString(k, v)
+		case "refresh", "retry", "expire", "minimum", "soa_ttl":
+			i, ok := l.toIntRange(k, v, math.MaxInt32)
+			if !ok {
+				continue
+			}
+			switch k {
+			case "refresh":
+				zone.Options.Refresh = i
+			case "retry":
+				zone.Options.Retry = i
+			case "expire":
+				zone.Options.Expire = i
+			case "minimum":
+				zone.Options.Minimum = i
+			case "soa_ttl":
+				zone.Options.SoaTtl = i
+			}
 		case "max_hosts":
-			zone.Options.MaxHosts = valueToInt(v)
+			zone.Options.MaxHosts, _ = l.toIntRange(k, v, math.MaxInt32)
 		case "targeting":
-			zone.Options.Targeting, err = parseTargets(v.(string))
+			str, ok := l.toString(k, v)
+			if !ok {
+				continue
+			}
+			targeting, err := parseTargets(str)
 			if err != nil {
-				log.Printf("Could not parse targeting '%s': %s", v, err)
-				return nil, err
+				l.errorf(k, "Could not parse targeting '%s': %s", str, err)
+				continue
 			}
+			zone.Options.Targeting = targeting
 
 		case "logging":
-			{
-				logging := new(ZoneLogging)
-				for logger, v := range v.(map[string]interface{}) {
-					switch logger {
-					case "stathat":
-						logging.StatHat = valueToBool(v)
-					case "stathat_api":
-						logging.StatHatAPI = valueToString(v)
-						logging.StatHat = true
-					default:
-						log.Println("Unknown logger option", logger)
-					}
+			obj, ok := l.toObject(k, v)
+			if !ok {
+				continue
+			}
+			logging := new(ZoneLogging)
+			for _, logger := range sortedKeys(obj) {
+				path := pathKey(k, logger)
+				switch logger {
+				case "stathat":
+					logging.StatHat, _ = l.toBool(path, obj[logger])
+				case "stathat_api":
+					logging.StatHatAPI, _ = l.toString(path, obj[logger])
+					logging.StatHat = true
+				default:
+					l.warnf(path, "Unknown logger option '%s'", logger)
 				}
-				zone.Logging = logging
-				// log.Printf("logging options: %#v", logging)
 			}
-			continue
+			zone.Logging = logging
 
 		case "data":
-			data = v.(map[string]interface{})
+			data, _ = l.toObject(k, v)
+		case "templates":
+			templates, _ = l.toObject(k, v)
+		case "transfer":
+			zone.Options.Transfer = l.setupTransfer(k, v)
+		case "reverse":
+			if !isReverseZone(zoneName) {
+				l.errorf(k, "Only in-addr.arpa and ip6.arpa zones can be reverse zones")
+				continue
+			}
+			sources, err := stringList(v)
+			if err != nil {
+				l.errorf(k, "%s", err)
+				continue
+			}
+			for i, source := range sources {
+				sources[i] = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(source), "."))
+			}
+			zone.Options.Reverse = sources
+
+		default:
+			l.warnf(k, "Unknown zone option '%s'", k)
 		}
 	}
 
-	setupZoneData(data, zone)
+	l.expandTemplates(templates, data)
+	l.setupZoneData(data, zone)
+
+	if serialMode != serialModeMtime && objmap["serial"] != nil {
+		l.warnf("serial", "The serial is ignored with the '%s' serial mode", serialMode)
+	}
+	if serialMode == serialModeHash && zone.Options.Transfer != nil {
+		l.warnf("serial_mode", "The 'hash' serial doesn't always increase, so secondary servers can miss changes (use 'date' for zone transfers)")
+	}
 
-	//log.Printf("ZO T: %T %s\n", Zones["0.us"], Zones["0.us"])
+	if l.errs.Fatal() {
+		return nil, l.errs
+	}
+	for _, e := range l.errs {
+		log.Printf("%s: %s", zoneName, e)
+	}
 
-	//log.Println("IP", string(Zone.Regions["0.us"].IPv4[0].ip))
+	if serialMode != serialModeMtime {
+		if serialMode == serialModeHash {
+			zone.Options.Serial = hashSerial(contentHash)
+		} else {
+			zone.Options.Serial = zoneSerials.dateSerial(Config.SerialStateFile(), zoneName, contentHash, time.Now())
+		}
+		if err := setupSOA(zone); err != nil {
+			return nil, err
+		}
+	}
 
 	switch {
 	case zone.Options.Targeting >= TargetRegionGroup:
@@ -253,81 +438,116 @@ func readZoneFile(zoneName, fileName string) (zone *Zone, zerr error) {
 	return zone, nil
 }
 
-func setupZoneData(data map[string]interface{}, Zone *Zone) {
-	recordTypes := map[string]uint16{
-		"a":     dns.TypeA,
-		"aaaa":  dns.TypeAAAA,
-		"alias": dns.TypeMF,
-		"cname": dns.TypeCNAME,
-		"mx":    dns.TypeMX,
-		"ns":    dns.TypeNS,
-		"txt":   dns.TypeTXT,
-		"spf":   dns.TypeSPF,
-		"srv":   dns.TypeSRV,
-		"ptr":   dns.TypePTR,
+func readZoneJSON(fileName string) (map[string]interface{}, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var objmap map[string]interface{}
+	decoder := json.NewDecoder(fh)
+	if err = decoder.Decode(&objmap); err != nil {
+		extra := ""
+		if serr, ok := err.(*json.SyntaxError); ok {
+			if _, serr := fh.Seek(0, os.SEEK_SET); serr != nil {
+				log.Fatalf("seek error: %v", serr)
+			}
+			line, col, highlight := errorutil.HighlightBytePosition(fh, serr.Offset)
+			extra = fmt.Sprintf(":\nError at line %d, column %d (file offset %d):\n%s",
+				line, col, serr.Offset, highlight)
+		}
+		return nil, fmt.Errorf("error parsing JSON object in config file %s%s\n%v",
+			fh.Name(), extra, err)
 	}
 
-	for dk, dv_inter := range data {
-		dv := dv_inter.(map[string]interface{})
+	return objmap, nil
+}
+
+var recordTypes = map[string]uint16{
+	"a":     dns.TypeA,
+	"aaaa":  dns.TypeAAAA,
+	"alias": dns.TypeMF,
+	"aname": dns.TypeMD,
+	"cname": dns.TypeCNAME,
+	"mx":    dns.TypeMX,
+	"ns":    dns.TypeNS,
+	"txt":   dns.TypeTXT,
+	"spf":   dns.TypeSPF,
+	"srv":   dns.TypeSRV,
+	"ptr":   dns.TypePTR,
+	"caa":   dns.TypeCAA,
+	"tlsa":  dns.TypeTLSA,
+	"sshfp": dns.TypeSSHFP,
+	"svcb":  TypeSVCB,
+	"https": TypeHTTPS,
+	"naptr": dns.TypeNAPTR,
+	"uri":   dns.TypeURI,
+}
 
-		//log.Printf("K %s V %s TYPE-V %T\n", dk, dv, dv)
+func (l *zoneLoader) setupZoneData(data map[string]interface{}, Zone *Zone) {
+	for _, dk := range sortedKeys(data) {
+		labelPath := pathKey("data", dk)
+
+		dv, ok := l.toObject(labelPath, data[dk])
+		if !ok {
+			continue
+		}
 
 		label := Zone.AddLabel(dk)
 
-		for rType, rdata := range dv {
+		for _, rType := range sortedKeys(dv) {
+			rdata := dv[rType]
+			path := pathKey(labelPath, rType)
+
 			switch rType {
 			case "max_hosts":
-				label.MaxHosts = valueToInt(rdata)
+				label.MaxHosts, _ = l.toIntRange(path, rdata, math.MaxInt32)
 				continue
 			case "ttl":
-				label.Ttl = valueToInt(rdata)
+				label.Ttl, _ = l.toIntRange(path, rdata, math.MaxInt32)
 				continue
 			}
 
 			dnsType, ok := recordTypes[rType]
 			if !ok {
-				log.Printf("Unsupported record type '%s'\n", rType)
+				l.warnf(path, "Unsupported record type '%s'", rType)
 				continue
 			}
 
 			if rdata == nil {
-				//log.Printf("No %s records for label %s\n", rType, dk)
 				continue
 			}
 
-			//log.Printf("rdata %s TYPE-R %T\n", rdata, rdata)
+			var records []interface{}
 
-			records := make(map[string][]interface{})
-
-			switch rdata.(type) {
+			switch rdata := rdata.(type) {
 			case map[string]interface{}:
+				if dnsType != dns.TypeNS {
+					// a single record
+					records = []interface{}{rdata}
+					break
+				}
 				// Handle NS map syntax, map[ns2.example.net:<nil> ns1.example.net:<nil>]
-				tmp := make([]interface{}, 0)
-				for rdataK, rdataV := range rdata.(map[string]interface{}) {
-					if rdataV == nil {
-						rdataV = ""
+				for _, ns := range sortedKeys(rdata) {
+					if rdata[ns] != nil && rdata[ns] != "" {
+						l.warnf(pathKey(path, ns), "NS records with names syntax not supported")
 					}
-					tmp = append(tmp, []string{rdataK, rdataV.(string)})
+					records = append(records, ns)
 				}
-				records[rType] = tmp
 			case string:
 				// CNAME and alias
-				tmp := make([]interface{}, 1)
-				tmp[0] = rdata.(string)
-				records[rType] = tmp
+				records = []interface{}{rdata}
+			case []interface{}:
+				records = rdata
 			default:
-				records[rType] = rdata.([]interface{})
+				l.errorf(path, "expected a list of records, got %s", describeValue(rdata))
+				continue
 			}
 
-			//log.Printf("RECORDS %s TYPE-REC %T\n", Records, Records)
-
-			label.Records[dnsType] = make(Records, len(records[rType]))
-
-			for i := 0; i < len(records[rType]); i++ {
-				//log.Printf("RT %T %#v\n", records[rType][i], records[rType][i])
-
-				record := new(Record)
+			label.Records[dnsType] = make(Records, 0, len(records))
 
+			for i, rec := range records {
 				var h dns.RR_Header
 				h.Class = dns.ClassINET
 				h.Rrtype = dnsType
@@ -342,189 +562,13 @@ func setupZoneData(data map[string]interface{}, Zone *Zone) {
 					h.Name = label.Label + "." + Zone.Origin + "."
 				}
 
-				switch dnsType {
-				case dns.TypeA, dns.TypeAAAA, dns.TypePTR:
-
-					str, weight := getStringWeight(records[rType][i].([]interface{}))
-					ip := str
-					record.Weight = weight
-
-					switch dnsType {
-					case dns.TypePTR:
-						record.RR = &dns.PTR{Hdr: h, Ptr: ip}
-						break
-					case dns.TypeA:
-						if x := net.ParseIP(ip); x != nil {
-							record.RR = &dns.A{Hdr: h, A: x}
-							break
-						}
-						panic(fmt.Errorf("Bad A record %s for %s", ip, dk))
-					case dns.TypeAAAA:
-						if x := net.ParseIP(ip); x != nil {
-							record.RR = &dns.AAAA{Hdr: h, AAAA: x}
-							break
-						}
-						panic(fmt.Errorf("Bad AAAA record %s for %s", ip, dk))
-					}
-
-				case dns.TypeMX:
-					rec := records[rType][i].(map[string]interface{})
-					pref := uint16(0)
-					mx := rec["mx"].(string)
-					if !strings.HasSuffix(mx, ".") {
-						mx = mx + "."
-					}
-					if rec["weight"] != nil {
-						record.Weight = valueToInt(rec["weight"])
-					}
-					if rec["preference"] != nil {
-						pref = uint16(valueToInt(rec["preference"]))
-					}
-					record.RR = &dns.MX{
-						Hdr:        h,
-						Mx:         mx,
-						Preference: pref}
-
-				case dns.TypeSRV:
-					rec := records[rType][i].(map[string]interface{})
-					priority := uint16(0)
-					srv_weight := uint16(0)
-					port := uint16(0)
-					target := rec["target"].(string)
-
-					if !dns.IsFqdn(target) {
-						target = target + "." + Zone.Origin
-					}
-
-					if rec["srv_weight"] != nil {
-						srv_weight = uint16(valueToInt(rec["srv_weight"]))
-					}
-					if rec["port"] != nil {
-						port = uint16(valueToInt(rec["port"]))
-					}
-					if rec["priority"] != nil {
-						priority = uint16(valueToInt(rec["priority"]))
-					}
-					record.RR = &dns.SRV{
-						Hdr:      h,
-						Priority: priority,
-						Weight:   srv_weight,
-						Port:     port,
-						Target:   target}
-
-				case dns.TypeCNAME:
-					rec := records[rType][i]
-					var target string
-					var weight int
-					switch rec.(type) {
-					case string:
-						target = rec.(string)
-					case []interface{}:
-						target, weight = getStringWeight(rec.([]interface{}))
-					}
-					if !dns.IsFqdn(target) {
-						target = target + "." + Zone.Origin
-					}
-					record.Weight = weight
-					record.RR = &dns.CNAME{Hdr: h, Target: dns.Fqdn(target)}
-
-				case dns.TypeMF:
-					rec := records[rType][i]
-					// MF records (how we store aliases) are not FQDNs
-					record.RR = &dns.MF{Hdr: h, Mf: rec.(string)}
-
-				case dns.TypeNS:
-					rec := records[rType][i]
-					if h.Ttl < 86400 {
-						h.Ttl = 86400
-					}
-
-					var ns string
-
-					switch rec.(type) {
-					case string:
-						ns = rec.(string)
-					case []string:
-						recl := rec.([]string)
-						ns = recl[0]
-						if len(recl[1]) > 0 {
-							log.Println("NS records with names syntax not supported")
-						}
-					default:
-						log.Printf("Data: %T %#v\n", rec, rec)
-						panic("Unrecognized NS format/syntax")
-					}
-
-					rr := &dns.NS{Hdr: h, Ns: dns.Fqdn(ns)}
-
-					record.RR = rr
-
-				case dns.TypeTXT:
-					rec := records[rType][i]
-
-					var txt string
-
-					switch rec.(type) {
-					case string:
-						txt = rec.(string)
-					case map[string]interface{}:
-
-						recmap := rec.(map[string]interface{})
-
-						if weight, ok := recmap["weight"]; ok {
-							record.Weight = valueToInt(weight)
-						}
-						if t, ok := recmap["txt"]; ok {
-							txt = t.(string)
-						}
-					}
-					if len(txt) > 0 {
-						rr := &dns.TXT{Hdr: h, Txt: []string{txt}}
-						record.RR = rr
-					} else {
-						log.Printf("Zero length txt record for '%s' in '%s'\n", label.Label, Zone.Origin)
-						continue
-					}
-					// Initial SPF support added here, cribbed from the TypeTXT case definition - SPF records should be handled identically
-
-				case dns.TypeSPF:
-					rec := records[rType][i]
-
-					var spf string
-
-					switch rec.(type) {
-					case string:
-						spf = rec.(string)
-					case map[string]interface{}:
-
-						recmap := rec.(map[string]interface{})
-
-						if weight, ok := recmap["weight"]; ok {
-							record.Weight = valueToInt(weight)
-						}
-						if t, ok := recmap["spf"]; ok {
-							spf = t.(string)
-						}
-					}
-					if len(spf) > 0 {
-						rr := &dns.SPF{Hdr: h, Txt: []string{spf}}
-						record.RR = rr
-					} else {
-						log.Printf("Zero length SPF record for '%s' in '%s'\n", label.Label, Zone.Origin)
-						continue
-					}
-
-				default:
-					log.Println("type:", rType)
-					panic("Don't know how to handle this type")
-				}
-
-				if record.RR == nil {
-					panic("record.RR is nil")
+				record, ok := l.newRecord(pathIndex(path, i), Zone, rType, h, rec)
+				if !ok {
+					continue
 				}
 
 				label.Weight[dnsType] += record.Weight
-				label.Records[dnsType][i] = *record
+				label.Records[dnsType] = append(label.Records[dnsType], *record)
 			}
 			if label.Weight[dnsType] > 0 {
 				sort.Sort(RecordsByWeight{label.Records[dnsType]})
@@ -544,89 +588,448 @@ func setupZoneData(data map[string]interface{}, Zone *Zone) {
 				}
 			}
 		}
-		if Zone.Labels[k].Ttl > 0 {
-			for _, records := range Zone.Labels[k].Records {
-				for _, r := range records {
-					r.RR.Header().Ttl = uint32(Zone.Labels[k].Ttl)
-				}
-			}
-		}
+		Zone.Labels[k].setTtls()
 	}
 	// do this again for GlobLabels
 	for _, label := range Zone.GlobLabels {
-		if label.Ttl > 0 {
-			for _, records := range label.Records {
-				for _, r := range records {
-					r.RR.Header().Ttl = uint32(label.Ttl)
-				}
+		label.setTtls()
+	}
+
+	if loop := Zone.findAliasLoop(); loop != nil {
+		l.errorf("data", "Alias loop: %s", strings.Join(quoteLabels(loop), " -> "))
+	}
+
+	if _, ok := Zone.Labels[""]; !ok {
+		l.warnf("data", "No records for the zone apex, you should probably add some NS records")
+	}
+
+	if err := setupSOA(Zone); err != nil {
+		l.errorf("contact", "%s", err)
+	}
+}
+
+// newRecord returns the record at path; false if it has errors or should
+// be skipped.
+func (l *zoneLoader) newRecord(path string, Zone *Zone, rType string, h dns.RR_Header, rec interface{}) (*Record, bool) {
+	errors := l.errors()
+	record := new(Record)
+	dnsType := h.Rrtype
+
+	switch dnsType {
+	case dns.TypeA, dns.TypeAAAA, dns.TypePTR:
+		str, weight, ttl, ok := l.stringWeight(path, rec)
+		if !ok {
+			return nil, false
+		}
+		record.Weight = weight
+		record.Ttl = ttl
+
+		switch dnsType {
+		case dns.TypePTR:
+			record.RR = &dns.PTR{Hdr: h, Ptr: str}
+		case dns.TypeA:
+			if x := net.ParseIP(str); x != nil && x.To4() != nil {
+				record.RR = &dns.A{Hdr: h, A: x}
+				break
+			}
+			l.errorf(path, "Bad A record '%s'", str)
+		case dns.TypeAAAA:
+			if x := net.ParseIP(str); x != nil {
+				record.RR = &dns.AAAA{Hdr: h, AAAA: x}
+				break
+			}
+			l.errorf(path, "Bad AAAA record '%s'", str)
+		}
+
+	case dns.TypeMX:
+		rec, ok := l.toObject(path, rec)
+		if !ok {
+			return nil, false
+		}
+		mx := l.stringField(path, rec, "mx")
+		if len(mx) == 0 {
+			l.errorf(path, "MX record is missing the mx host name")
+		}
+		if !strings.HasSuffix(mx, ".") {
+			mx = mx + "."
+		}
+		record.Weight = l.intField(path, rec, "weight", math.MaxInt32)
+		record.RR = &dns.MX{
+			Hdr:        h,
+			Mx:         mx,
+			Preference: uint16(l.intField(path, rec, "preference", math.MaxUint16))}
+
+	case dns.TypeSRV:
+		rec, ok := l.toObject(path, rec)
+		if !ok {
+			return nil, false
+		}
+		target := l.stringField(path, rec, "target")
+		if len(target) == 0 {
+			l.errorf(path, "SRV record is missing a target")
+		}
+		if !dns.IsFqdn(target) {
+			target = target + "." + Zone.Origin + "."
+		}
+		record.Weight = l.intField(path, rec, "weight", math.MaxInt32)
+		record.RR = &dns.SRV{
+			Hdr:      h,
+			Priority: uint16(l.intField(path, rec, "priority", math.MaxUint16)),
+			Weight:   uint16(l.intField(path, rec, "srv_weight", math.MaxUint16)),
+			Port:     uint16(l.intField(path, rec, "port", math.MaxUint16)),
+			Target:   target}
+
+	case dns.TypeNAPTR:
+		rec, ok := l.toObject(path, rec)
+		if !ok {
+			return nil, false
+		}
+		regexp := l.stringField(path, rec, "regexp")
+		replacement := "."
+		if rec["replacement"] != nil {
+			replacement = l.stringField(path, rec, "replacement")
+			if !dns.IsFqdn(replacement) {
+				replacement = replacement + "." + Zone.Origin + "."
 			}
 		}
+		if len(regexp) > 0 && replacement != "." {
+			l.errorf(path, "NAPTR record can't have both a regexp and a replacement")
+		}
+		record.Weight = l.intField(path, rec, "weight", math.MaxInt32)
+		record.RR = &dns.NAPTR{
+			Hdr:         h,
+			Order:       uint16(l.intField(path, rec, "order", math.MaxUint16)),
+			Preference:  uint16(l.intField(path, rec, "preference", math.MaxUint16)),
+			Flags:       l.stringField(path, rec, "flags"),
+			Service:     l.stringField(path, rec, "service"),
+			Regexp:      regexp,
+			Replacement: replacement}
+
+	case dns.TypeURI:
+		rec, ok := l.toObject(path, rec)
+		if !ok {
+			return nil, false
+		}
+		target := l.stringField(path, rec, "target")
+		if len(target) == 0 {
+			l.errorf(path, "URI record is missing a target")
+		}
+		record.Weight = l.intField(path, rec, "weight", math.MaxInt32)
+		record.RR = &dns.URI{
+			Hdr:      h,
+			Priority: uint16(l.intField(path, rec, "priority", math.MaxUint16)),
+			Weight:   uint16(l.intField(path, rec, "uri_weight", math.MaxUint16)),
+			Target:   target}
+
+	case dns.TypeCAA:
+		rec, ok := l.toObject(path, rec)
+		if !ok {
+			return nil, false
+		}
+		tag := l.stringField(path, rec, "tag")
+		if len(tag) == 0 {
+			l.errorf(path, "CAA record is missing a tag")
+		}
+		record.Weight = l.intField(path, rec, "weight", math.MaxInt32)
+		record.RR = &dns.CAA{
+			Hdr:   h,
+			Flag:  uint8(l.intField(path, rec, "flag", math.MaxUint8)),
+			Tag:   strings.ToLower(tag),
+			Value: l.stringField(path, rec, "value")}
+
+	case dns.TypeTLSA:
+		rec, ok := l.toObject(path, rec)
+		if !ok {
+			return nil, false
+		}
+		matchingType := uint8(l.intField(path, rec, "matching_type", math.MaxUint8))
+		certificate, err := hexData(rec["certificate"], tlsaDataLength[matchingType])
+		if err != nil {
+			l.errorf(pathKey(path, "certificate"), "Bad TLSA record: %s", err)
+		}
+		record.Weight = l.intField(path, rec, "weight", math.MaxInt32)
+		record.RR = &dns.TLSA{
+			Hdr:          h,
+			Usage:        uint8(l.intField(path, rec, "usage", math.MaxUint8)),
+			Selector:     uint8(l.intField(path, rec, "selector", math.MaxUint8)),
+			MatchingType: matchingType,
+			Certificate:  certificate}
+
+	case dns.TypeSSHFP:
+		rec, ok := l.toObject(path, rec)
+		if !ok {
+			return nil, false
+		}
+		fpType := uint8(l.intField(path, rec, "type", math.MaxUint8))
+		fingerprint, err := hexData(rec["fingerprint"], sshfpDataLength[fpType])
+		if err != nil {
+			l.errorf(pathKey(path, "fingerprint"), "Bad SSHFP record: %s", err)
+		}
+		record.Weight = l.intField(path, rec, "weight", math.MaxInt32)
+		record.RR = &dns.SSHFP{
+			Hdr:         h,
+			Algorithm:   uint8(l.intField(path, rec, "algorithm", math.MaxUint8)),
+			Type:        fpType,
+			FingerPrint: fingerprint}
+
+	case TypeSVCB, TypeHTTPS:
+		rec, ok := l.toObject(path, rec)
+		if !ok {
+			return nil, false
+		}
+		priority := uint16(1)
+		if rec["priority"] != nil {
+			priority = uint16(l.intField(path, rec, "priority", math.MaxUint16))
+		}
+		target := "."
+		if rec["target"] != nil {
+			target = l.stringField(path, rec, "target")
+			if !dns.IsFqdn(target) {
+				target = target + "." + Zone.Origin
+			}
+		}
+		record.Weight = l.intField(path, rec, "weight", math.MaxInt32)
+		var params map[string]interface{}
+		if rec["params"] != nil {
+			params, _ = l.toObject(pathKey(path, "params"), rec["params"])
+		}
+		if l.errors() > errors {
+			return nil, false
+		}
+		rr, err := newSVCB(h, priority, target, params)
+		if err != nil {
+			if perr, ok := err.(*svcParamError); ok {
+				path = pathKey(pathKey(path, "params"), perr.name)
+			}
+			l.errorf(path, "Bad %s record: %s", strings.ToUpper(rType), err)
+			return nil, false
+		}
+		record.RR = rr
+
+	case dns.TypeCNAME:
+		target, weight, ttl, ok := l.stringWeight(path, rec)
+		if !ok {
+			return nil, false
+		}
+		if !dns.IsFqdn(target) {
+			target = target + "." + Zone.Origin
+		}
+		record.Weight = weight
+		record.Ttl = ttl
+		record.RR = &dns.CNAME{Hdr: h, Target: dns.Fqdn(target)}
+
+	case dns.TypeMF:
+		// MF records (how we store aliases) are not FQDNs
+		target, ok := rec.(string)
+		if !ok {
+			l.errorf(path, "expected the name of the aliased label, got %s", describeValue(rec))
+			return nil, false
+		}
+		record.RR = &dns.MF{Hdr: h, Mf: target}
+
+	case dns.TypeMD:
+		// MD records are how we store ANAMEs (resolved with the
+		// upstream resolver when queried)
+		target, weight, ttl, ok := l.stringWeight(path, rec)
+		if !ok {
+			return nil, false
+		}
+		if !dns.IsFqdn(target) {
+			target = target + "." + Zone.Origin
+		}
+		record.Weight = weight
+		record.Ttl = ttl
+		record.RR = &dns.MD{Hdr: h, Md: dns.Fqdn(target)}
+
+	case dns.TypeNS:
+		ns, ok := rec.(string)
+		if !ok {
+			l.errorf(path, "expected an NS host name, got %s", describeValue(rec))
+			return nil, false
+		}
+		record.RR = &dns.NS{Hdr: h, Ns: dns.Fqdn(ns)}
+
+	case dns.TypeTXT, dns.TypeSPF:
+		// SPF records are handled identically to TXT records, except
+		// the key in the object syntax is "spf"
+		txt := l.txtStrings(path, rec, rType, record)
+		if l.errors() > errors {
+			return nil, false
+		}
+		if len(txt) == 0 {
+			l.warnf(path, "Zero length %s record", strings.ToUpper(rType))
+			return nil, false
+		}
+		if dnsType == dns.TypeSPF {
+			record.RR = &dns.SPF{Hdr: h, Txt: txt}
+		} else {
+			record.RR = &dns.TXT{Hdr: h, Txt: txt}
+		}
+		buf := make([]byte, dns.MaxMsgSize)
+		if _, err := dns.PackRR(record.RR, buf, 0, nil, false); err != nil {
+			l.errorf(path, "%s record is too long: %s", strings.ToUpper(rType), err)
+		}
 	}
 
-	setupSOA(Zone)
+	// the object syntaxes can have a TTL for the record
+	if obj, ok := rec.(map[string]interface{}); ok && obj["ttl"] != nil {
+		record.Ttl = l.intField(path, obj, "ttl", math.MaxInt32)
+	}
 
-	//log.Println(Zones[k])
+	if l.errors() > errors || record.RR == nil {
+		return nil, false
+	}
+	return record, true
 }
 
-func getStringWeight(rec []interface{}) (string, int) {
-	str := rec[0].(string)
-	var weight int
+// txtStrings returns the character-strings for a TXT or SPF record. A
+// record is either a string, a list of strings or an object with the
+// strings under the record type key and optionally a weight. Strings
+// longer than 255 bytes are split into multiple character-strings.
+func (l *zoneLoader) txtStrings(path string, rec interface{}, key string, record *Record) []string {
+	var strs []interface{}
 
-	if len(rec) > 1 {
-		switch rec[1].(type) {
+	switch rec := rec.(type) {
+	case string:
+		strs = []interface{}{rec}
+	case []interface{}:
+		strs = rec
+	case map[string]interface{}:
+		record.Weight = l.intField(path, rec, "weight", math.MaxInt32)
+		path = pathKey(path, key)
+		switch t := rec[key].(type) {
 		case string:
-			var err error
-			weight, err = strconv.Atoi(rec[1].(string))
-			if err != nil {
-				panic("Error converting weight to integer")
+			strs = []interface{}{t}
+		case []interface{}:
+			strs = t
+		case nil:
+		default:
+			l.errorf(path, "expected a string or a list of strings, got %s", describeValue(t))
+		}
+	default:
+		l.errorf(path, "expected a string, a list of strings or an object, got %s", describeValue(rec))
+	}
+
+	txt := []string{}
+	for i, str := range strs {
+		s, ok := str.(string)
+		if !ok {
+			l.errorf(pathIndex(path, i), "expected a string, got %s", describeValue(str))
+			continue
+		}
+		if len(s) > 0 {
+			txt = append(txt, splitTxt(s)...)
+		}
+	}
+	return txt
+}
+
+// splitTxt splits s into strings of at most 255 bytes (in wire format), taking
+// care not to break up \DDD and \X escape sequences.
+func splitTxt(s string) []string {
+	var parts []string
+	start, n := 0, 0
+	for i := 0; i < len(s); n++ {
+		if n == 255 {
+			parts = append(parts, s[start:i])
+			start, n = i, 0
+		}
+		l := 1
+		if s[i] == '\\' && i+1 < len(s) {
+			l = 2
+			if i+3 < len(s) && isDigits(s[i+1:i+4]) {
+				l = 4
 			}
-		case float64:
-			weight = int(rec[1].(float64))
 		}
+		i += l
 	}
+	return append(parts, s[start:])
+}
 
-	return str, weight
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
 }
 
-func setupSOA(Zone *Zone) {
+// quoteLabels quotes the label names for error messages (so the apex
+// label isn't just an empty string).
+func quoteLabels(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "'" + name + "'"
+	}
+	return quoted
+}
+
+// Expected data lengths (in bytes) for the TLSA matching types and SSHFP
+// fingerprint types; 0 (full certificate/key) isn't checked.
+var (
+	tlsaDataLength  = map[uint8]int{1: 32, 2: 64}
+	sshfpDataLength = map[uint8]int{1: 20, 2: 32}
+)
+
+// hexData checks that v is a non-empty hex string (of the expected length
+// in bytes, if size is set) and returns it in lower case.
+func hexData(v interface{}, size int) (string, error) {
+	str, ok := v.(string)
+	if !ok || len(str) == 0 {
+		return "", fmt.Errorf("missing hex data")
+	}
+	data, err := hex.DecodeString(str)
+	if err != nil {
+		return "", fmt.Errorf("invalid hex data '%s': %s", str, err)
+	}
+	if size > 0 && len(data) != size {
+		return "", fmt.Errorf("hex data '%s' is %d bytes, expected %d", str, len(data), size)
+	}
+	return strings.ToLower(str), nil
+}
+
+func setupSOA(Zone *Zone) error {
 	label := Zone.Labels[""]
 
 	primaryNs := "ns"
 
-	// log.Println("LABEL", label)
-
 	if label == nil {
-		log.Println(Zone.Origin, "doesn't have any 'root' records,",
-			"you should probably add some NS records")
 		label = Zone.AddLabel("")
 	}
 
-	if record, ok := label.Records[dns.TypeNS]; ok {
+	if record, ok := label.Records[dns.TypeNS]; ok && len(record) > 0 {
 		primaryNs = record[0].RR.(*dns.NS).Ns
 	}
-
-	ttl := Zone.Options.Ttl * 10
-	if ttl > 3600 {
-		ttl = 3600
+	if len(Zone.Options.PrimaryNs) > 0 {
+		primaryNs = dns.Fqdn(Zone.Options.PrimaryNs)
 	}
+
+	ttl := Zone.Options.SoaTtl
 	if ttl == 0 {
-		ttl = 600
+		ttl = Zone.Options.Ttl * 10
+		if ttl > 3600 {
+			ttl = 3600
+		}
+		if ttl == 0 {
+			ttl = 600
+		}
 	}
 
+	// the minimum is the TTL for negative answers (RFC 2308), refresh,
+	// retry and expire are for secondary servers
 	s := Zone.Origin + ". " + strconv.Itoa(ttl) + " IN SOA " +
 		primaryNs + " " + Zone.Options.Contact + " " +
-		strconv.Itoa(Zone.Options.Serial) +
-		// refresh, retry, expire, minimum are all
-		// meaningless with this implementation
-		" 5400 5400 1209600 3600"
-
-	// log.Println("SOA: ", s)
+		strconv.Itoa(Zone.Options.Serial) + " " +
+		strconv.Itoa(Zone.Options.Refresh) + " " +
+		strconv.Itoa(Zone.Options.Retry) + " " +
+		strconv.Itoa(Zone.Options.Expire) + " " +
+		strconv.Itoa(Zone.Options.Minimum)
 
 	rr, err := dns.NewRR(s)
 
 	if err != nil {
-		log.Println("SOA Error", err)
-		panic("Could not setup SOA")
+		return fmt.Errorf("Could not setup SOA: %s", err)
 	}
 
 	record := Record{RR: rr}
@@ -634,66 +1037,80 @@ func setupSOA(Zone *Zone) {
 	label.Records[dns.TypeSOA] = make([]Record, 1)
 	label.Records[dns.TypeSOA][0] = record
 
+	return nil
 }
 
-func valueToBool(v interface{}) (rv bool) {
-	switch v.(type) {
+func valueToBool(v interface{}) (bool, error) {
+	switch v := v.(type) {
 	case bool:
-		rv = v.(bool)
+		return v, nil
 	case string:
-		str := v.(string)
-		switch str {
-		case "true":
-			rv = true
-		case "1":
-			rv = true
+		switch v {
+		case "true", "1":
+			return true, nil
+		case "false", "0", "":
+			return false, nil
 		}
 	case float64:
-		if v.(float64) > 0 {
-			rv = true
-		}
-	default:
-		log.Println("Can't convert", v, "to bool")
-		panic("Can't convert value")
+		return v > 0, nil
 	}
-	return rv
-
+	return false, fmt.Errorf("can't convert %s to a boolean", describeValue(v))
 }
 
-func valueToString(v interface{}) (rv string) {
-	switch v.(type) {
+func valueToString(v interface{}) (string, error) {
+	switch v := v.(type) {
 	case string:
-		rv = v.(string)
+		return v, nil
 	case float64:
-		rv = strconv.FormatFloat(v.(float64), 'f', -1, 64)
-	default:
-		log.Println("Can't convert", v, "to string")
-		panic("Can't convert value")
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
 	}
-	return rv
+	return "", fmt.Errorf("can't convert %s to a string", describeValue(v))
 }
 
-func valueToInt(v interface{}) (rv int) {
-	switch v.(type) {
+func valueToInt(v interface{}) (int, error) {
+	switch v := v.(type) {
 	case string:
-		i, err := strconv.Atoi(v.(string))
+		i, err := strconv.Atoi(v)
 		if err != nil {
-			panic("Error converting weight to integer")
+			return 0, fmt.Errorf("can't convert %s to an integer", describeValue(v))
 		}
-		rv = i
+		return i, nil
 	case float64:
-		rv = int(v.(float64))
-	default:
-		log.Println("Can't convert", v, "to integer")
-		panic("Can't convert value")
+		// beyond 2^53 a float64 can't hold every integer
+		if v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+			return 0, fmt.Errorf("%s isn't a valid integer", formatNumber(v))
+		}
+		return int(v), nil
 	}
-	return rv
+	return 0, fmt.Errorf("can't convert %s to an integer", describeValue(v))
 }
 
 func zoneNameFromFile(fileName string) string {
 	return fileName[0:strings.LastIndex(fileName, ".")]
 }
 
+func isZoneFile(fileName string) bool {
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".json", ".yaml", ".yml", ".zone":
+		return true
+	}
+	return false
+}
+
+// sha256Files returns a hash of the contents of all the files.
+func sha256Files(fileNames []string) string {
+	if len(fileNames) == 1 {
+		return sha256File(fileNames[0])
+	}
+	hashes := make([]string, len(fileNames))
+	for i, fn := range fileNames {
+		hashes[i] = sha256File(fn)
+	}
+	hasher := sha256.New()
+	hasher.Write([]byte(strings.Join(hashes, " ")))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
 func sha256File(fn string) string {
 	if data, err := ioutil.ReadFile(fn); err != nil {
 		return ""
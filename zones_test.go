@@ -1,10 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 	. "gopkg.in/check.v1"
@@ -24,6 +29,9 @@ func (s *ConfigSuite) SetUpSuite(c *C) {
 	s.zones = make(Zones)
 	lastRead = map[string]*ZoneReadRecord{}
 	s.srv = &Server{}
+	s.srv.SetResolver(testResolver())
+	// the zone handlers are shared with the serve tests
+	s.srv.SetTsigSecrets(testTsigSecrets)
 	s.srv.zonesReadDir("dns", s.zones)
 }
 
@@ -127,3 +135,956 @@ func CopyFile(c *C, src, dst string) (int64, error) {
 	defer df.Close()
 	return io.Copy(df, sf)
 }
+
+func (s *ConfigSuite) TestCAARecords(c *C) {
+	tz := s.zones["test.example.com"]
+
+	label, qtype := tz.findLabels("", []string{"@"}, qTypes{dns.TypeCAA})
+	c.Check(qtype, Equals, dns.TypeCAA)
+	Caa := label.Records[dns.TypeCAA]
+	c.Assert(Caa, HasLen, 2)
+	c.Check(Caa[0].RR.(*dns.CAA).Flag, Equals, uint8(0))
+	c.Check(Caa[0].RR.(*dns.CAA).Tag, Equals, "issue")
+	c.Check(Caa[0].RR.(*dns.CAA).Value, Equals, "letsencrypt.org")
+	c.Check(Caa[1].RR.(*dns.CAA).Flag, Equals, uint8(128))
+	c.Check(Caa[1].RR.(*dns.CAA).Tag, Equals, "iodef")
+
+	// targeted CAA records
+	label, qtype = tz.findLabels("", []string{"dk", "europe", "@"}, qTypes{dns.TypeCAA})
+	c.Check(qtype, Equals, dns.TypeCAA)
+	Caa = label.Records[dns.TypeCAA]
+	c.Assert(Caa, HasLen, 1)
+	c.Check(Caa[0].RR.(*dns.CAA).Value, Equals, "ca.example.eu")
+
+	// no weights, so all records are returned
+	c.Check(label.Picker(dns.TypeCAA, 1), HasLen, 1)
+	c.Check(tz.Labels[""].Picker(dns.TypeCAA, 1), HasLen, 2)
+}
+
+func (s *ConfigSuite) TestTLSAAndSSHFPRecords(c *C) {
+	tz := s.zones["test.example.com"]
+
+	label, qtype := tz.findLabels("_25._tcp.mail", []string{"@"}, qTypes{dns.TypeTLSA})
+	c.Check(qtype, Equals, dns.TypeTLSA)
+	Tlsa := label.Records[dns.TypeTLSA]
+	c.Assert(Tlsa, HasLen, 1)
+	tlsa := Tlsa[0].RR.(*dns.TLSA)
+	c.Check(tlsa.Usage, Equals, uint8(3))
+	c.Check(tlsa.Selector, Equals, uint8(1))
+	c.Check(tlsa.MatchingType, Equals, uint8(1))
+	c.Check(tlsa.Certificate, Equals, "0c72ac70b745ac19998811b131d662c9ac69dbdbe7cb23e5b514b56664c5d3d6")
+
+	label, qtype = tz.findLabels("ssh", []string{"@"}, qTypes{dns.TypeSSHFP})
+	c.Check(qtype, Equals, dns.TypeSSHFP)
+	Sshfp := label.Records[dns.TypeSSHFP]
+	c.Assert(Sshfp, HasLen, 2)
+	c.Check(Sshfp[0].RR.(*dns.SSHFP).Algorithm, Equals, uint8(4))
+	c.Check(Sshfp[0].RR.(*dns.SSHFP).Type, Equals, uint8(2))
+	c.Check(Sshfp[1].RR.(*dns.SSHFP).FingerPrint, Equals, "dd465c09cfa51fb45020cc83316fff21b9ec74ac")
+}
+
+func (s *ConfigSuite) TestBadHexRecords(c *C) {
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	bad := map[string]string{
+		"tlsa":  `{ "data": { "_443._tcp": { "tlsa": [ { "usage": 3, "selector": 1, "matching_type": 1, "certificate": "not-hex" } ] } } }`,
+		"short": `{ "data": { "_443._tcp": { "tlsa": [ { "usage": 3, "selector": 1, "matching_type": 1, "certificate": "abcd" } ] } } }`,
+		"sshfp": `{ "data": { "ssh": { "sshfp": [ { "algorithm": 4, "type": 2, "fingerprint": "12345" } ] } } }`,
+	}
+
+	for name, data := range bad {
+		fileName := dir + "/" + name + ".json"
+		err = ioutil.WriteFile(fileName, []byte(data), 0644)
+		c.Assert(err, IsNil)
+
+		zone, err := readZoneFile(name, fileName)
+		c.Check(zone, IsNil)
+		c.Check(err, ErrorMatches, "(?s).*hex data.*")
+	}
+}
+
+func (s *ConfigSuite) TestNAPTRAndURIRecords(c *C) {
+	tz := s.zones["test.example.com"]
+
+	label, qtype := tz.findLabels("sip", []string{"@"}, qTypes{dns.TypeNAPTR})
+	c.Check(qtype, Equals, dns.TypeNAPTR)
+	Naptr := label.Records[dns.TypeNAPTR]
+	c.Assert(Naptr, HasLen, 2)
+	// sorted by weight
+	naptr := Naptr[0].RR.(*dns.NAPTR)
+	c.Check(naptr.Order, Equals, uint16(100))
+	c.Check(naptr.Preference, Equals, uint16(10))
+	c.Check(naptr.Flags, Equals, "S")
+	c.Check(naptr.Service, Equals, "SIP+D2U")
+	c.Check(naptr.Replacement, Equals, "_sip._udp.test.example.com.")
+	c.Check(Naptr[1].RR.(*dns.NAPTR).Replacement, Equals, "_sip._tcp.example.com.")
+	c.Check(label.Picker(dns.TypeNAPTR, 1), HasLen, 1)
+
+	label, qtype = tz.findLabels("sip", []string{"dk", "europe", "@"}, qTypes{dns.TypeNAPTR})
+	c.Check(qtype, Equals, dns.TypeNAPTR)
+	Naptr = label.Records[dns.TypeNAPTR]
+	c.Assert(Naptr, HasLen, 1)
+	c.Check(Naptr[0].RR.(*dns.NAPTR).Regexp, Equals, "!^.*$!sip:eu@example.com!")
+	c.Check(Naptr[0].RR.(*dns.NAPTR).Replacement, Equals, ".")
+
+	label, qtype = tz.findLabels("_ftp._tcp", []string{"@"}, qTypes{dns.TypeURI})
+	c.Check(qtype, Equals, dns.TypeURI)
+	Uri := label.Records[dns.TypeURI]
+	c.Assert(Uri, HasLen, 2)
+	c.Check(Uri[0].RR.(*dns.URI).Priority, Equals, uint16(10))
+	c.Check(Uri[0].RR.(*dns.URI).Weight, Equals, uint16(1))
+	c.Check(Uri[1].RR.(*dns.URI).Target, Equals, "ftp://ftp2.example.com/public")
+	// no geodns weights, so both are returned
+	c.Check(label.Picker(dns.TypeURI, 1), HasLen, 2)
+}
+
+func (s *ConfigSuite) TestTxtStrings(c *C) {
+	tz := s.zones["test.example.com"]
+
+	label, _ := tz.findLabels("long-txt", []string{"@"}, qTypes{dns.TypeTXT})
+	Txt := label.Records[dns.TypeTXT]
+	c.Assert(Txt, HasLen, 1)
+	txt := Txt[0].RR.(*dns.TXT).Txt
+	c.Assert(txt, HasLen, 2)
+	c.Check(len(txt[0]), Equals, 255)
+	c.Check(strings.Join(txt, ""), Matches, "v=DKIM1; k=rsa; p=.{392}")
+
+	label, _ = tz.findLabels("multi-txt", []string{"@"}, qTypes{dns.TypeTXT})
+	Txt = label.Records[dns.TypeTXT]
+	c.Assert(Txt, HasLen, 1)
+	c.Check(Txt[0].RR.(*dns.TXT).Txt, DeepEquals,
+		[]string{"v=DKIM1; k=rsa;", "p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQ"})
+
+	Spf := label.Records[dns.TypeSPF]
+	c.Assert(Spf, HasLen, 1)
+	c.Check(Spf[0].Weight, Equals, 1)
+	c.Check(Spf[0].RR.(*dns.SPF).Txt, DeepEquals, []string{"v=spf1 ip4:192.168.1.0/24", " -all"})
+
+	// escape sequences aren't split and count as one byte
+	a := strings.Repeat("a", 254)
+	c.Check(splitTxt(a+`\065b`), DeepEquals, []string{a + `\065`, "b"})
+	c.Check(splitTxt(a+`b\"c`), DeepEquals, []string{a + "b", `\"c`})
+	c.Check(splitTxt("short"), DeepEquals, []string{"short"})
+
+	// records that can't be packed are rejected
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fileName := dir + "/long.example.com.json"
+	data := `{ "data": { "": { "txt": "` + strings.Repeat("x", 70000) + `" } } }`
+	err = ioutil.WriteFile(fileName, []byte(data), 0644)
+	c.Assert(err, IsNil)
+	zone, err := readZoneFile("long.example.com", fileName)
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, `error: data\[""\]\.txt\[0\]: TXT record is too long.*`)
+}
+
+func (s *ConfigSuite) TestAliasLoopZone(c *C) {
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fileName := dir + "/loop.example.com.json"
+	data := `{ "data": { "": { "alias": "a" }, "a": { "alias": "b" }, "b": { "alias": "" } } }`
+	err = ioutil.WriteFile(fileName, []byte(data), 0644)
+	c.Assert(err, IsNil)
+
+	zone, err := readZoneFile("loop.example.com", fileName)
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, ".*Alias loop: '' -> 'a' -> 'b' -> ''")
+}
+
+func (s *ConfigSuite) TestMasterFile(c *C) {
+	tz := s.zones["test.example.net"]
+	c.Assert(tz, NotNil)
+
+	// options from the SOA record and the companion JSON file
+	c.Check(tz.Options.Serial, Equals, 2016020101)
+	c.Check(tz.Options.Contact, Equals, "hostmaster.example.net.")
+	c.Check(tz.Options.Targeting.String(), Equals, "@ continent country")
+
+	apex := tz.Labels[""]
+	c.Check(apex.Records[dns.TypeNS], HasLen, 2)
+	c.Check(apex.firstRR(dns.TypeMX).(*dns.MX).Mx, Equals, "mail.test.example.net.")
+	c.Check(apex.firstRR(dns.TypeTXT).(*dns.TXT).Txt, DeepEquals, []string{"v=spf1 mx -all"})
+	soa := apex.firstRR(dns.TypeSOA).(*dns.SOA)
+	c.Check(soa.Serial, Equals, uint32(2016020101))
+	c.Check(soa.Ns, Equals, "ns1.example.net.")
+	c.Check([]uint32{soa.Refresh, soa.Retry, soa.Expire, soa.Minttl}, DeepEquals, []uint32{7200, 900, 604800, 300})
+	c.Check(soa.Hdr.Ttl, Equals, uint32(3600))
+
+	// the lowest TTL is used for the label, records with a higher TTL
+	// keep it
+	mail := tz.Labels["mail"]
+	c.Check(mail.Ttl, Equals, 300)
+	c.Check(mail.firstRR(dns.TypeA).(*dns.A).A.String(), Equals, "192.0.2.25")
+	c.Check(mail.firstRR(dns.TypeA).Header().Ttl, Equals, uint32(300))
+	c.Check(mail.firstRR(dns.TypeAAAA).Header().Ttl, Equals, uint32(600))
+
+	c.Check(tz.Labels["ftp"].firstRR(dns.TypeCNAME).(*dns.CNAME).Target, Equals, "www.test.example.net.")
+	c.Check(tz.Labels["_sip._udp"].firstRR(dns.TypeSRV).(*dns.SRV).Target, Equals, "sip.test.example.net.")
+
+	label, qtype := tz.findLabels("foo.users", []string{"@"}, qTypes{dns.TypeA})
+	c.Check(qtype, Equals, dns.TypeA)
+	c.Check(label.firstRR(dns.TypeA).(*dns.A).A.String(), Equals, "192.0.2.100")
+
+	// HTTPS records in the RFC 3597 form
+	https := tz.Labels["svc"].Records[TypeHTTPS]
+	c.Assert(https, HasLen, 1)
+	c.Check(https[0].RR.(*dns.RFC3597).Rdata, Equals, "000100000100030268320003000220fb")
+
+	// unsupported record types are skipped
+	_, ok := tz.Labels["info"]
+	c.Check(ok, Equals, false)
+
+	// records from the JSON file replace the master file records
+	www := tz.Labels["www"]
+	c.Assert(www.Records[dns.TypeA], HasLen, 2)
+	c.Check(www.Records[dns.TypeA][0].RR.(*dns.A).A.String(), Equals, "192.0.2.82")
+	c.Check(www.Records[dns.TypeA][0].RR.Header().Ttl, Equals, uint32(120))
+
+	label, _ = tz.findLabels("www", []string{"europe", "@"}, qTypes{dns.TypeA})
+	c.Check(label.firstRR(dns.TypeA).(*dns.A).A.String(), Equals, "198.51.100.80")
+}
+
+func (s *ConfigSuite) TestBadMasterFile(c *C) {
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fileName := dir + "/bad.example.net.zone"
+	err = ioutil.WriteFile(fileName, []byte("www IN A 192.0.2\n"), 0644)
+	c.Assert(err, IsNil)
+
+	zone, err := readZoneFile("bad.example.net", fileName)
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, "error parsing master file .*bad.example.net.zone: .*")
+}
+
+func (s *ConfigSuite) TestYAMLZone(c *C) {
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	jsonData := `{
+  "serial": 3,
+  "max_hosts": 2,
+  "logging": { "stathat": true },
+  "data": {
+    "": {
+      "ns": { "ns1.example.net.": null, "ns2.example.net.": null },
+      "mx": [ { "preference": 10, "mx": "mx.example.net.", "weight": 1 } ]
+    },
+    "no": { "a": [ [ "192.168.1.2", 10 ], [ "192.168.1.3", 10 ] ], "ttl": "601" },
+    "0": { "a": [ [ "192.168.0.1", 10 ] ] },
+    "www": { "cname": "geo.bitnames.com." }
+  }
+}`
+	yamlData := `# the same zone as YAML
+serial: 3
+max_hosts: 2
+logging:
+  stathat: true
+data:
+  "":
+    ns: { ns1.example.net.: ~, ns2.example.net.: ~ }
+    mx:
+      - { preference: 10, mx: mx.example.net., weight: 1 }
+  no:
+    # balanced between the two web servers
+    a: [ [ 192.168.1.2, 10 ], [ 192.168.1.3, 10 ] ]
+    ttl: "601"
+  0:
+    a: [ [ 192.168.0.1, 10 ] ]
+  www:
+    cname: geo.bitnames.com.
+`
+	jsonFile := dir + "/yaml.example.com.json"
+	yamlFile := dir + "/yaml.example.com.yaml"
+	c.Assert(ioutil.WriteFile(jsonFile, []byte(jsonData), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(yamlFile, []byte(yamlData), 0644), IsNil)
+
+	jsonMap, err := readZoneJSON(jsonFile)
+	c.Assert(err, IsNil)
+	yamlMap, err := readZoneYAML(yamlFile)
+	c.Assert(err, IsNil)
+	c.Check(yamlMap, DeepEquals, jsonMap)
+
+	zone, err := readZoneFile("yaml.example.com", yamlFile)
+	c.Assert(err, IsNil)
+	c.Check(zone.Labels["no"].Records[dns.TypeA], HasLen, 2)
+	c.Check(zone.Labels["no"].firstRR(dns.TypeA).Header().Ttl, Equals, uint32(601))
+
+	// a zone can't have both
+	zone, err = readZoneFile("yaml.example.com", jsonFile, yamlFile)
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, "more than one JSON or YAML file.*")
+
+	badFile := dir + "/bad.example.com.yml"
+	bad := "data:\n  www:\n    a: [ [ 192.168.1.2, 10 ]\n  ftp:\n"
+	c.Assert(ioutil.WriteFile(badFile, []byte(bad), 0644), IsNil)
+	zone, err = readZoneFile("bad.example.com", badFile)
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, "(?s)error parsing YAML in config file .*bad.example.com.yml:\nError at line 3:\n.*    3:     a: \\[ \\[ 192.168.1.2, 10 \\]\n.*did not find expected.*")
+}
+
+func (s *ConfigSuite) TestZoneErrors(c *C) {
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fileName := dir + "/errors.example.com.json"
+
+	problems := func(data string) []string {
+		err := ioutil.WriteFile(fileName, []byte(data), 0644)
+		c.Assert(err, IsNil)
+
+		zone, err := readZoneFile("errors.example.com", fileName)
+		c.Check(zone, IsNil)
+		c.Assert(err, FitsTypeOf, ZoneErrors{})
+
+		list := []string{}
+		for _, e := range err.(ZoneErrors) {
+			list = append(list, e.Error())
+		}
+		return list
+	}
+
+	// problems found by the schema
+	c.Check(problems(`{
+  "ttl": "soon",
+  "data": {
+    "": {
+      "ns": [ "ns1.example.net." ]
+    },
+    "www.europe": {
+      "mx": [ { "mx": "mx.example.net", "preference": 70000, "weigth": 1 } ],
+      "srv": { "port": 25 },
+      "a": [ [ "192.0.2.1", 1, 2147483648 ] ]
+    }
+  }
+}`), DeepEquals, []string{
+		"error: data[\"www.europe\"].a[0][2]: 2147483648 is out of range (0-2147483647)",
+		"error: data[\"www.europe\"].mx[0].preference: 70000 is out of range (0-65535)",
+		"error: data[\"www.europe\"].mx[0].weigth: Unknown key 'weigth'",
+		"error: data[\"www.europe\"].srv: missing 'target'",
+		"error: ttl: expected an integer, got \"soon\"",
+	})
+
+	// unknown options, record types and logging options are errors too
+	c.Check(problems(`{
+  "max_host": 2,
+  "logging": { "statshat": true },
+  "data": {
+    "": {
+      "ns": [ "ns1.example.net." ],
+      "hinfo": "unsupported"
+    }
+  }
+}`), DeepEquals, []string{
+		"error: data[\"\"].hinfo: Unknown key 'hinfo'",
+		"error: logging.statshat: Unknown key 'statshat'",
+		"error: max_host: Unknown key 'max_host'",
+	})
+
+	// the deprecated origin option is ignored with a warning
+	c.Check(problems(`{
+  "origin": "errors.example.com.",
+  "data": {
+    "": { "ns": [ "ns1.example.net." ] },
+    "foo": { "a": [ "192.168.1" ] }
+  }
+}`), DeepEquals, []string{
+		"warning: origin: The origin option is deprecated and ignored",
+		"error: data.foo.a[0]: Bad A record '192.168.1'",
+	})
+
+	// and by the loader
+	c.Check(problems(`{
+  "data": {
+    "": {
+      "ns": [ "ns1.example.net." ],
+      "txt": [ "", "not empty" ]
+    },
+    "foo": { "a": [ [ "192.168.1.1" ], [ "192.168.1.2", 10 ], [ "192.168.1" ] ] }
+  }
+}`), DeepEquals, []string{
+		"warning: data[\"\"].txt[0]: Zero length TXT record",
+		"error: data.foo.a[2]: Bad A record '192.168.1'",
+	})
+
+	// without the errors the zone loads, and skipped records don't leave
+	// empty slots
+	data := `{ "data": { "": { "ns": [ "ns1.example.net." ], "txt": [ "", "not empty" ] } } }`
+	err = ioutil.WriteFile(fileName, []byte(data), 0644)
+	c.Assert(err, IsNil)
+
+	zone, err := readZoneFile("errors.example.com", fileName)
+	c.Assert(err, IsNil)
+	Txt := zone.Labels[""].Records[dns.TypeTXT]
+	c.Assert(Txt, HasLen, 1)
+	c.Check(Txt[0].RR.(*dns.TXT).Txt, DeepEquals, []string{"not empty"})
+}
+
+func (s *ConfigSuite) TestZoneSchema(c *C) {
+	// the embedded schema is up to date (run go generate)
+	schema, err := ioutil.ReadFile("zone.schema.json")
+	c.Assert(err, IsNil)
+	c.Check(FSMustString(false, "/zone.schema.json"), Equals, string(schema))
+
+	errs := validateZoneSchema(map[string]interface{}{
+		"logging": map[string]interface{}{"stathat": "maybe"},
+		"data": map[string]interface{}{
+			"foo": map[string]interface{}{
+				"a":     []interface{}{[]interface{}{"192.168.1.1", 10.0, 20.0, 30.0}},
+				"cname": 5.0,
+				"txt":   []interface{}{map[string]interface{}{"spf": "v=spf1 -all"}},
+				"https": map[string]interface{}{"params": map[string]interface{}{"key65": "x", "alpn2": "h2"}},
+			},
+		},
+	})
+	list := []string{}
+	for _, e := range errs {
+		list = append(list, e.Error())
+	}
+	c.Check(list, DeepEquals, []string{
+		"error: data.foo.a[0]: expected at most 3 items",
+		"error: data.foo.cname: expected a string or a list of strings or [ string, weight, ttl ] records, got '5'",
+		"error: data.foo.https.params.alpn2: Unknown key 'alpn2'",
+		"error: data.foo.txt[0].spf: Unknown key 'spf'",
+		"error: logging.stathat: expected a boolean, got \"maybe\"",
+	})
+}
+
+func (s *ConfigSuite) TestTemplates(c *C) {
+	tz := s.zones["test.example.com"]
+
+	ips := func(label *Label, qtype uint16) []string {
+		list := []string{}
+		for _, r := range label.Records[qtype] {
+			switch rr := r.RR.(type) {
+			case *dns.A:
+				list = append(list, rr.A.String()+" "+strconv.Itoa(r.Weight))
+			case *dns.AAAA:
+				list = append(list, rr.AAAA.String()+" "+strconv.Itoa(r.Weight))
+			}
+		}
+		return list
+	}
+
+	label := tz.Labels["tmpl"]
+	c.Check(ips(label, dns.TypeA), DeepEquals, []string{"192.168.10.1 10", "192.168.10.2 10"})
+	c.Check(ips(label, dns.TypeAAAA), DeepEquals, []string{"fd06:c1d3:e902::10 10"})
+	c.Check(label.Ttl, Equals, 300)
+
+	// combined templates, extended and re-weighted; sorted by weight
+	label = tz.Labels["tmpl.europe"]
+	c.Check(ips(label, dns.TypeA), DeepEquals,
+		[]string{"192.168.30.1 20", "192.168.10.1 10", "192.168.20.1 1", "192.168.10.2 0"})
+	c.Check(label.Weight[dns.TypeA], Equals, 31)
+
+	// records in the label replace the template records
+	label = tz.Labels["tmpl.asia"]
+	c.Check(ips(label, dns.TypeA), HasLen, 2)
+	c.Check(ips(label, dns.TypeAAAA), DeepEquals, []string{"fd06:c1d3:e902::30 10"})
+
+	// the templates are only used through labels
+	_, ok := tz.Labels["web"]
+	c.Check(ok, Equals, false)
+
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fileName := dir + "/tmpl.example.com.json"
+	data := `{
+  "templates": { "web": { "a": [ "192.168.10.1" ], "template": "other" } },
+  "data": {
+    "": { "ns": [ "ns1.example.net." ] },
+    "www": { "template": [ "web", "missing" ], "extend": { "ttl": 10 }, "weights": { "192.168.1.1": 10 } }
+  }
+}`
+	err = ioutil.WriteFile(fileName, []byte(data), 0644)
+	c.Assert(err, IsNil)
+
+	zone, err := readZoneFile("tmpl.example.com", fileName)
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, `4 problems:
+error: templates.web.template: Templates can't use 'template'
+error: data.www.template: Unknown template 'missing'
+error: data.www.extend.ttl: Only records can be added with extend
+warning: data.www.weights\["192.168.1.1"\]: No records for '192.168.1.1' to re-weight`)
+}
+
+func (s *ConfigSuite) TestGenerate(c *C) {
+	tz := s.zones["test.example.com"]
+
+	for i, name := range []string{"node-001", "node-002", "node-003"} {
+		label := tz.Labels[name]
+		c.Assert(label, NotNil, Commentf("label %s", name))
+		c.Check(label.firstRR(dns.TypeA).(*dns.A).A.String(), Equals, "192.168.40."+strconv.Itoa(i+1))
+		c.Check(label.firstRR(dns.TypeTXT).(*dns.TXT).Txt, DeepEquals, []string{"node " + strconv.Itoa(i+1) + " of 3"})
+	}
+	_, ok := tz.Labels["node-${0,3}"]
+	c.Check(ok, Equals, false)
+
+	// generated labels can use templates
+	for _, i := range []int{0, 2, 4, 6} {
+		label := tz.Labels["pool-"+strconv.Itoa(i)+".europe"]
+		c.Assert(label, NotNil)
+		c.Assert(label.Records[dns.TypeA], HasLen, 3)
+		c.Check(label.Records[dns.TypeA][2].RR.(*dns.A).A.String(), Equals, "192.168.50."+strconv.Itoa(i))
+		c.Check(label.Records[dns.TypeA][2].Weight, Equals, 5)
+	}
+	_, ok = tz.Labels["pool-1.europe"]
+	c.Check(ok, Equals, false)
+
+	for _, t := range []struct{ s, expected string }{
+		{"host-$", "host-7"},
+		{"${0,3}", "007"},
+		{"${10}", "17"},
+		{"${-7,2}", "00"},
+		{"${0,4,x}.${0,0,o}", "0007.7"},
+		{"${8,0,X}", "F"},
+		{"price: \\$$", "price: $7"},
+	} {
+		str, err := generateString(t.s, 7)
+		c.Check(err, IsNil)
+		c.Check(str, Equals, t.expected, Commentf("%s", t.s))
+	}
+	for _, t := range []string{"${1", "${a}", "${0,3,b}", "${0,1,d,4}"} {
+		_, err := generateString(t, 7)
+		c.Check(err, NotNil, Commentf("%s", t))
+	}
+
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fileName := dir + "/gen.example.com.json"
+	data := `{
+  "data": {
+    "": { "ns": [ "ns1.example.net." ] },
+    "a-$": { "generate": "0-20000", "a": [ "192.168.1.1" ] },
+    "b-$": { "generate": "5-1", "a": [ "192.168.1.1" ] },
+    "c": { "generate": "1-2", "a": [ "192.168.1.$" ] },
+    "d-${1": { "generate": "1-2", "a": [ "192.168.1.$" ] },
+    "e-$": { "generate": "1-2", "a": [ "192.168.1.$" ] },
+    "e-2": { "a": [ "192.168.1.1" ] }
+  }
+}`
+	err = ioutil.WriteFile(fileName, []byte(data), 0644)
+	c.Assert(err, IsNil)
+
+	zone, err := readZoneFile("gen.example.com", fileName)
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, `5 problems:
+error: data\["a-\$"\].generate: Can't generate more than 10000 labels in a zone
+error: data\["b-\$"\].generate: Invalid range '5-1', expected start-stop or start-stop/step
+error: data.c: Generated label 'c' already exists
+error: data\["d-\${1"\]: Missing '}' in 'd-\${1'
+error: data\["e-\$"\]: Generated label 'e-2' already exists`)
+
+	// the schema is checked after the labels are generated
+	data = `{
+  "data": {
+    "": { "ns": [ "ns1.example.net." ] },
+    "mx-$": { "generate": "1-2", "mx": { "mx": "mx$.example.net.", "preference": "${10}" } },
+    "srv-$": { "generate": "1-2", "srv": { "target": "srv.example.net.", "port": "$x" } }
+  }
+}`
+	err = ioutil.WriteFile(fileName, []byte(data), 0644)
+	c.Assert(err, IsNil)
+
+	zone, err = readZoneFile("gen.example.com", fileName)
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, `2 problems:
+error: data.srv-1.srv.port: expected an integer \(0-65535\), got "1x"
+error: data.srv-2.srv.port: expected an integer \(0-65535\), got "2x"`)
+
+	data = strings.Replace(data, "$x", "$", 1)
+	err = ioutil.WriteFile(fileName, []byte(data), 0644)
+	c.Assert(err, IsNil)
+
+	zone, err = readZoneFile("gen.example.com", fileName)
+	c.Assert(err, IsNil)
+	c.Check(zone.Labels["mx-2"].firstRR(dns.TypeMX).(*dns.MX).Preference, Equals, uint16(12))
+	c.Check(zone.Labels["srv-2"].firstRR(dns.TypeSRV).(*dns.SRV).Port, Equals, uint16(2))
+}
+
+func (s *ConfigSuite) TestReverseZones(c *C) {
+	ptr := func(zone *Zone, ip string) string {
+		addr, err := dns.ReverseAddr(ip)
+		c.Assert(err, IsNil)
+		name, ok := zone.LabelName(addr)
+		c.Assert(ok, Equals, true)
+		label := zone.Labels[name]
+		if label == nil || len(label.Records[dns.TypePTR]) == 0 {
+			return ""
+		}
+		c.Check(label.Records[dns.TypePTR], HasLen, 1)
+		return label.firstRR(dns.TypePTR).(*dns.PTR).Ptr
+	}
+
+	rz := s.zones["1.168.192.in-addr.arpa"]
+	c.Assert(rz, NotNil)
+	c.Check(rz.Options.Reverse, DeepEquals, []string{"test.example.com"})
+
+	// explicit PTR records take precedence
+	c.Check(ptr(rz, "192.168.1.2"), Equals, "bar.example.com.")
+	c.Check(ptr(rz, "192.168.1.5"), Equals, "three.two.one.test.example.com.")
+	// the shortest name is used
+	c.Check(ptr(rz, "192.168.1.3"), Equals, "foo.test.example.com.")
+	c.Check(ptr(rz, "192.168.1.4"), Equals, "foo.test.example.com.")
+	c.Check(ptr(rz, "192.168.1.99"), Equals, "")
+	c.Check(rz.Labels["5"].firstRR(dns.TypePTR).Header().Ttl, Equals, uint32(600))
+
+	rz6 := s.zones["2.0.9.e.3.d.1.c.6.0.d.f.ip6.arpa"]
+	c.Assert(rz6, NotNil)
+	c.Check(ptr(rz6, "fd06:c1d3:e902::2"), Equals, "foo.test.example.com.")
+	c.Check(ptr(rz6, "fd06:c1d3:e902:202:a5ff:fecd:13a6:a"), Equals, "foo.test.example.com.")
+	// targeted labels aren't used
+	c.Check(ptr(rz6, "fd06:c1d3:e902::30"), Equals, "")
+	addr, _ := dns.ReverseAddr("fd06:c1d3:e902::2")
+	name, _ := rz6.LabelName(addr)
+	_, ok := rz6.Labels[name[strings.Index(name, ".")+1:]]
+	c.Check(ok, Equals, true)
+
+	// only reverse zones can have PTR records from other zones
+	zone, err := readZoneFile("example.com", "dns/2.0.9.e.3.d.1.c.6.0.d.f.ip6.arpa.json")
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, "error: reverse: Only in-addr.arpa and ip6.arpa zones can be reverse zones")
+}
+
+func (s *ConfigSuite) TestReverseZoneReload(c *C) {
+	// restore the dns.Mux
+	defer s.srv.zonesReadDir("dns", s.zones)
+
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	writeZone := func(name, data string, mtime time.Time) {
+		fileName := dir + "/" + name + ".json"
+		c.Assert(ioutil.WriteFile(fileName, []byte(data), 0644), IsNil)
+		c.Assert(os.Chtimes(fileName, mtime, mtime), IsNil)
+	}
+	ptr := func(name string) string {
+		zone := s.zones["2.0.192.in-addr.arpa"]
+		c.Assert(zone, NotNil)
+		if label, ok := zone.Labels[name]; ok && len(label.Records[dns.TypePTR]) > 0 {
+			return label.firstRR(dns.TypePTR).(*dns.PTR).Ptr
+		}
+		return ""
+	}
+
+	serial := func() uint32 {
+		return s.zones["2.0.192.in-addr.arpa"].SoaRR().(*dns.SOA).Serial
+	}
+
+	now := time.Now()
+	writeZone("2.0.192.in-addr.arpa", `{ "reverse": "rev.example.com", "data": { "": { "ns": "ns1.example.net" } } }`, now)
+	writeZone("rev.example.com", `{ "data": { "www": { "a": [ "192.0.2.1" ] }, "europe": { "a": [ "192.0.2.1" ] } } }`, now)
+
+	// "europe" is a targeted label for the apex, not a name
+	s.srv.zonesReadDir(dir, s.zones)
+	c.Check(ptr("1"), Equals, "www.rev.example.com.")
+	c.Check(serial(), Equals, uint32(now.Unix()))
+
+	// the serial of the reverse zone comes from the newer source
+	writeZone("rev.example.com", `{ "data": { "web": { "a": [ "192.0.2.2" ] } } }`, now.Add(time.Minute))
+	s.srv.zonesReadDir(dir, s.zones)
+	c.Check(ptr("1"), Equals, "")
+	c.Check(ptr("2"), Equals, "web.rev.example.com.")
+	c.Check(serial(), Equals, uint32(now.Unix()+60))
+
+	// and goes up when the source is removed
+	os.Remove(dir + "/rev.example.com.json")
+	s.srv.zonesReadDir(dir, s.zones)
+	c.Check(ptr("2"), Equals, "")
+	c.Check(serial(), Equals, uint32(now.Unix()+61))
+}
+
+func (s *ConfigSuite) TestSOAOptions(c *C) {
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fileName := dir + "/soa.example.com.json"
+	data := `{
+  "ttl": 60,
+  "primary_ns": "ns0.example.net",
+  "refresh": 3600,
+  "retry": "600",
+  "expire": 86400,
+  "minimum": 30,
+  "soa_ttl": 120,
+  "data": { "": { "ns": [ "ns1.example.net." ] } }
+}`
+	c.Assert(ioutil.WriteFile(fileName, []byte(data), 0644), IsNil)
+
+	zone, err := readZoneFile("soa.example.com", fileName)
+	c.Assert(err, IsNil)
+	soa := zone.SoaRR().(*dns.SOA)
+	c.Check(soa.Ns, Equals, "ns0.example.net.")
+	c.Check([]uint32{soa.Refresh, soa.Retry, soa.Expire, soa.Minttl}, DeepEquals, []uint32{3600, 600, 86400, 30})
+	c.Check(soa.Hdr.Ttl, Equals, uint32(120))
+
+	for _, t := range []struct{ data, err string }{
+		{`{ "minimum": -1 }`, `error: minimum: -1 is out of range \(0-2147483647\)`},
+		{`{ "expire": 2147483648 }`, `error: expire: 2147483648 is out of range \(0-2147483647\)`},
+		{`{ "expire": "4294967296" }`, `(?s).*error: expire: 4294967296 is out of range \(0-2147483647\).*`},
+		{`{ "serial": 4294967296 }`, `(?s).*error: serial: 4294967296 is out of range \(0-4294967295\).*`},
+	} {
+		c.Assert(ioutil.WriteFile(fileName, []byte(t.data), 0644), IsNil)
+		zone, err = readZoneFile("soa.example.com", fileName)
+		c.Check(zone, IsNil)
+		c.Check(err, ErrorMatches, t.err)
+	}
+
+	// serials are 32 bit unsigned, in JSON and master files
+	c.Assert(ioutil.WriteFile(fileName, []byte(`{ "serial": 3000000000, "data": { "": { "ns": [ "ns1.example.net." ] } } }`), 0644), IsNil)
+	zone, err = readZoneFile("soa.example.com", fileName)
+	c.Assert(err, IsNil)
+	c.Check(zone.SoaRR().(*dns.SOA).Serial, Equals, uint32(3000000000))
+
+	masterFile := dir + "/soa.example.com.zone"
+	master := "@ 3600 IN SOA ns1.example.net. hostmaster.example.net. 3000000000 7200 900 604800 300\n@ IN NS ns1.example.net.\n"
+	c.Assert(ioutil.WriteFile(masterFile, []byte(master), 0644), IsNil)
+	zone, err = readZoneFile("soa.example.com", masterFile)
+	c.Assert(err, IsNil)
+	c.Check(zone.SoaRR().(*dns.SOA).Serial, Equals, uint32(3000000000))
+
+	_, err = valueToInt(1.5)
+	c.Check(err, ErrorMatches, "1.5 isn't a valid integer")
+	_, err = valueToInt(1e20)
+	c.Check(err, ErrorMatches, "100000000000000000000 isn't a valid integer")
+}
+
+func (s *ConfigSuite) TestRecordTtl(c *C) {
+	label := s.zones["test.example.com"].Labels["ttl-mixed"]
+	c.Assert(label, NotNil)
+
+	a := label.Records[dns.TypeA]
+	c.Assert(a, HasLen, 2)
+	c.Check(a[0].Ttl, Equals, 60)
+	c.Check(a[0].RR.Header().Ttl, Equals, uint32(60))
+	c.Check(a[1].Ttl, Equals, 0)
+	c.Check(a[1].RR.Header().Ttl, Equals, uint32(300))
+	c.Check(label.firstRR(dns.TypeMX).Header().Ttl, Equals, uint32(7200))
+
+	// the records returned together get the lowest TTL
+	picked := label.Picker(dns.TypeA, label.MaxHosts)
+	c.Assert(picked, HasLen, 2)
+	for _, r := range picked {
+		c.Check(r.RR.Header().Ttl, Equals, uint32(60))
+	}
+	// without changing the records in the zone
+	c.Check(a[1].RR.Header().Ttl, Equals, uint32(300))
+}
+
+func (s *ConfigSuite) TestSerialModes(c *C) {
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name, data string) string {
+		fileName := dir + "/" + name
+		c.Assert(ioutil.WriteFile(fileName, []byte(data), 0644), IsNil)
+		return fileName
+	}
+	serial := func(zoneName string, fileNames ...string) int {
+		zone, err := readZoneFile(zoneName, fileNames...)
+		c.Assert(err, IsNil)
+		c.Check(zone.SoaRR().(*dns.SOA).Serial, Equals, uint32(zone.Options.Serial))
+		return zone.Options.Serial
+	}
+
+	// the same content has the same serial, however it's written
+	jsonFile := writeFile("hash.example.com.json", `{ "serial_mode": "hash",
+  "data": { "": { "ns": [ "ns1.example.net." ] }, "www": { "a": [ "192.0.2.1" ] } } }`)
+	yamlFile := writeFile("hash2.example.com.yaml", `serial_mode: hash
+data:
+  www:
+    a: [ 192.0.2.1 ]
+  "":
+    ns: [ ns1.example.net. ]
+`)
+	hash := serial("hash.example.com", jsonFile)
+	c.Check(hash > 0, Equals, true)
+	c.Check(serial("hash2.example.com", yamlFile), Equals, hash)
+
+	os.Chtimes(jsonFile, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	c.Check(serial("hash.example.com", jsonFile), Equals, hash)
+
+	jsonFile = writeFile("hash.example.com.json", `{ "serial_mode": "hash",
+  "data": { "": { "ns": [ "ns1.example.net." ] }, "www": { "a": [ "192.0.2.2" ] } } }`)
+	c.Check(serial("hash.example.com", jsonFile), Not(Equals), hash)
+
+	// date serials without a state file
+	date := writeFile("date.example.com.json", `{ "serial_mode": "date", "data": { "": { "ns": [ "ns1.example.net." ] } } }`)
+	today, _ := strconv.Atoi(time.Now().UTC().Format("20060102") + "00")
+	first := serial("date.example.com", date)
+	c.Check(first >= today, Equals, true)
+	c.Check(serial("date.example.com", date), Equals, first)
+
+	zone, err := readZoneFile("bad.example.com", writeFile("bad.example.com.json", `{ "serial_mode": "date", "serial": 5 }`))
+	c.Check(err, IsNil)
+	c.Check(zone.Options.Serial >= today, Equals, true)
+
+	// the hash serial can go down, which secondary servers don't expect
+	// (the warning is only returned together with an error)
+	hashed := writeFile("hash.example.com.json", `{ "serial_mode": "hash", "transfer": { "allow": [ "127.0.0.1" ] }, "data": { "www": { "a": [ "bad" ] } } }`)
+	zone, err = readZoneFile("hash.example.com", hashed)
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, `(?s).*warning: serial_mode: The 'hash' serial doesn't always increase.*`)
+
+	zone, err = readZoneFile("bad.example.com", writeFile("bad.example.com.json", `{ "serial_mode": "random" }`))
+	c.Check(zone, IsNil)
+	c.Check(err, ErrorMatches, `error: serial_mode: .*`)
+}
+
+func (s *ConfigSuite) TestDateSerialState(c *C) {
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	stateFile := dir + "/serials.json"
+	day := time.Date(2016, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	store := new(serialStore)
+	c.Check(store.dateSerial(stateFile, "example.com", "a", day), Equals, 2016020100)
+	c.Check(store.dateSerial(stateFile, "example.com", "a", day), Equals, 2016020100)
+	c.Check(store.dateSerial(stateFile, "example.com", "b", day), Equals, 2016020101)
+	c.Check(store.dateSerial(stateFile, "example.net", "a", day), Equals, 2016020100)
+
+	// the serials are kept in the state file
+	store = new(serialStore)
+	c.Check(store.dateSerial(stateFile, "example.com", "b", day), Equals, 2016020101)
+	c.Check(store.dateSerial(stateFile, "example.com", "c", day), Equals, 2016020102)
+	c.Check(store.dateSerial(stateFile, "example.com", "d", day.AddDate(0, 0, 1)), Equals, 2016020200)
+
+	// and never go backwards
+	c.Check(store.dateSerial(stateFile, "example.com", "e", day), Equals, 2016020201)
+
+	// -checkconfig doesn't save the state
+	*flagcheckconfig = true
+	defer func() { *flagcheckconfig = false }()
+	c.Check(store.dateSerial(stateFile, "example.com", "f", day), Equals, 2016020202)
+	store = new(serialStore)
+	c.Check(store.dateSerial(stateFile, "example.com", "e", day), Equals, 2016020201)
+}
+
+func (s *ConfigSuite) TestTransferOptions(c *C) {
+	t := s.zones["test.example.com"].Options.Transfer
+	c.Assert(t, NotNil)
+	c.Check(t.View, DeepEquals, []string{"europe", "@"})
+	c.Check(t.allowedIP(net.ParseIP("127.0.0.2")), Equals, true)
+	c.Check(t.allowedIP(net.ParseIP("::1")), Equals, true)
+	c.Check(t.allowedIP(net.ParseIP("192.0.2.1")), Equals, false)
+
+	t = s.zones["test.example.net"].Options.Transfer
+	c.Assert(t, NotNil)
+	c.Check(t.Keys, DeepEquals, []string{"transfer-key."})
+	c.Check(t.View, DeepEquals, []string{"@"})
+	c.Check(t.hasKey("Transfer-Key."), Equals, true)
+
+	c.Check(s.zones["test.example.org"].Options.Transfer, IsNil)
+
+	for addr, expected := range map[string]string{
+		"192.0.2.1":           "192.0.2.1:53",
+		"2001:db8::53":        "[2001:db8::53]:53",
+		"[2001:db8::53]:5353": "[2001:db8::53]:5353",
+		"ns1.example.net":     "ns1.example.net:53",
+	} {
+		notify, err := dnsAddr(addr)
+		c.Check(err, IsNil)
+		c.Check(notify, Equals, expected)
+	}
+
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fileName := dir + "/bad.example.com.json"
+	for _, t := range []struct{ data, err string }{
+		{`{ "transfer": { "view": "europe" } }`, `(?s).*error: transfer: Zone transfers need 'allow' or 'tsig' \(or both\).*`},
+		{`{ "transfer": { "allow": [ "192.0.2.0/33" ] } }`, `(?s).*error: transfer.allow\[0\]: Bad address or network '192.0.2.0/33'.*`},
+		{`{ "transfer": { "allow": "::1", "notify": [ "192.0.2.1", "ns:99999" ] } }`, `(?s).*error: transfer.notify\[1\]: Bad address 'ns:99999'.*`},
+	} {
+		c.Assert(ioutil.WriteFile(fileName, []byte(t.data), 0644), IsNil)
+		zone, err := readZoneFile("bad.example.com", fileName)
+		c.Check(zone, IsNil)
+		c.Check(err, ErrorMatches, t.err)
+	}
+}
+
+func (s *ConfigSuite) TestTransferHistory(c *C) {
+	dir, err := ioutil.TempDir("", "geodns-test.")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	fileName := dir + "/ixfr.example.com.json"
+	version := func(serial int, www string) *Zone {
+		data := fmt.Sprintf(`{ "serial": %d, "transfer": { "allow": "127.0.0.1" },
+  "data": { "": { "ns": [ "ns1.example.net." ] }, "www": { "a": [ "%s" ] } } }`, serial, www)
+		c.Assert(ioutil.WriteFile(fileName, []byte(data), 0644), IsNil)
+		zone, err := readZoneFile("ixfr.example.com", fileName)
+		c.Assert(err, IsNil)
+		return zone
+	}
+	str := func(rrs []dns.RR) []string {
+		list := make([]string, len(rrs))
+		for i, rr := range rrs {
+			if soa, ok := rr.(*dns.SOA); ok {
+				list[i] = fmt.Sprintf("SOA %d", soa.Serial)
+			} else {
+				list[i] = rr.(*dns.A).A.String()
+			}
+		}
+		return list
+	}
+
+	v1 := version(1, "192.0.2.1")
+	v1.setupTransferHistory(nil)
+	c.Check(v1.TransferHistory, HasLen, 0)
+
+	v2 := version(2, "192.0.2.2")
+	v2.setupTransferHistory(v1)
+	c.Assert(v2.TransferHistory, HasLen, 1)
+
+	v3 := version(3, "192.0.2.3")
+	v3.setupTransferHistory(v2)
+	c.Check(str(v3.ixfrRecords(3)), DeepEquals, []string{"SOA 3"})
+	c.Check(str(v3.ixfrRecords(2)), DeepEquals, []string{"SOA 3", "SOA 2", "192.0.2.2", "SOA 3", "192.0.2.3", "SOA 3"})
+	c.Check(str(v3.ixfrRecords(1)), DeepEquals, []string{"SOA 3",
+		"SOA 1", "192.0.2.1", "SOA 2", "192.0.2.2",
+		"SOA 2", "192.0.2.2", "SOA 3", "192.0.2.3", "SOA 3"})
+
+	// not in the history, the whole zone
+	c.Check(v3.ixfrRecords(7), HasLen, 4)
+
+	// the same version again keeps the history
+	same := version(3, "192.0.2.3")
+	same.setupTransferHistory(v3)
+	c.Check(same.TransferHistory, HasLen, 2)
+
+	// a change without a new serial clears it
+	changed := version(3, "192.0.2.4")
+	changed.setupTransferHistory(v3)
+	c.Check(changed.TransferHistory, HasLen, 0)
+
+	// only the last versions are kept
+	zone := v3
+	for serial := 4; serial < 20; serial++ {
+		next := version(serial, fmt.Sprintf("192.0.2.%d", serial))
+		next.setupTransferHistory(zone)
+		zone = next
+	}
+	c.Check(zone.TransferHistory, HasLen, transferHistory)
+	c.Check(zone.TransferHistory[0].From.(*dns.SOA).Serial, Equals, uint32(19-transferHistory))
+	c.Check(zone.ixfrRecords(3), HasLen, 4)
+}
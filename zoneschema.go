@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// validateZoneSchema checks the zone data (as decoded from JSON or YAML)
+// against the zone JSON Schema (zone.schema.json, embedded in templates.go
+// with go generate). Only the parts of JSON Schema used in the zone schema
+// are implemented.
+func validateZoneSchema(objmap map[string]interface{}) ZoneErrors {
+	v := &schemaValidator{root: zoneSchema()}
+	v.validate("", v.root, objmap)
+	return v.errs
+}
+
+var (
+	zoneSchemaOnce sync.Once
+	zoneSchemaData map[string]interface{}
+)
+
+func zoneSchema() map[string]interface{} {
+	zoneSchemaOnce.Do(func() {
+		schema, err := FSByte(development, "/zone.schema.json")
+		if err != nil {
+			panic(fmt.Sprintf("could not read the zone schema: %s", err))
+		}
+		if err := json.Unmarshal(schema, &zoneSchemaData); err != nil {
+			panic(fmt.Sprintf("invalid zone schema: %s", err))
+		}
+	})
+	return zoneSchemaData
+}
+
+type schemaValidator struct {
+	root map[string]interface{}
+	errs ZoneErrors
+	// errors from values of the wrong type (rather than, say, a missing
+	// key in an object of the right type)
+	mismatches map[*ZoneError]bool
+}
+
+func (v *schemaValidator) errorf(path, format string, a ...interface{}) {
+	v.errs = append(v.errs, &ZoneError{Path: path, Severity: SeverityError, Message: fmt.Sprintf(format, a...)})
+}
+
+func (v *schemaValidator) mismatchf(path, format string, a ...interface{}) {
+	v.errorf(path, format, a...)
+	v.markMismatch(v.errs[len(v.errs)-1])
+}
+
+func (v *schemaValidator) markMismatch(e *ZoneError) {
+	if v.mismatches == nil {
+		v.mismatches = make(map[*ZoneError]bool)
+	}
+	v.mismatches[e] = true
+}
+
+func (v *schemaValidator) resolve(schema map[string]interface{}) map[string]interface{} {
+	for {
+		ref, ok := schema["$ref"].(string)
+		if !ok {
+			return schema
+		}
+		schema = v.root
+		for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			schema = schema[key].(map[string]interface{})
+		}
+	}
+}
+
+func (v *schemaValidator) validate(path string, schema map[string]interface{}, value interface{}) {
+	schema = v.resolve(schema)
+
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		v.validateAnyOf(path, schema, anyOf, value)
+		return
+	}
+
+	if types := schemaTypes(schema); len(types) > 0 {
+		if !matchesType(types, value) {
+			v.mismatchf(path, "expected %s, got %s", schemaExpected(schema), describeValue(value))
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if e == value {
+				found = true
+			}
+		}
+		if !found {
+			v.mismatchf(path, "expected %s, got %s", schemaExpected(schema), describeValue(value))
+		}
+	}
+
+	switch value := value.(type) {
+	case string:
+		if pattern, ok := schema["pattern"].(string); ok {
+			if !schemaRegexp(pattern).MatchString(value) {
+				v.mismatchf(path, "expected %s, got %s", schemaExpected(schema), describeValue(value))
+			}
+		}
+	case float64:
+		min, hasMin := schema["minimum"].(float64)
+		max, hasMax := schema["maximum"].(float64)
+		if (hasMin && value < min) || (hasMax && value > max) {
+			v.errorf(path, "%s is out of range (%s-%s)", formatNumber(value), formatNumber(min), formatNumber(max))
+		}
+	case []interface{}:
+		v.validateArray(path, schema, value)
+	case map[string]interface{}:
+		v.validateObject(path, schema, value)
+	}
+}
+
+func (v *schemaValidator) validateAnyOf(path string, schema map[string]interface{}, anyOf []interface{}, value interface{}) {
+	// Report the errors from the alternative that got furthest (the
+	// deepest path, then the fewest errors), ignoring the alternatives
+	// that are just for another type of value.
+	var best *schemaValidator
+	bestDepth := 0
+
+	for _, s := range anyOf {
+		sub := &schemaValidator{root: v.root}
+		sub.validate(path, s.(map[string]interface{}), value)
+		if len(sub.errs) == 0 {
+			return
+		}
+
+		depth := -1
+		for _, e := range sub.errs {
+			if e.Path == path && sub.mismatches[e] {
+				continue
+			}
+			if len(e.Path) > depth {
+				depth = len(e.Path)
+			}
+		}
+		if depth < 0 {
+			continue
+		}
+		if best == nil || depth > bestDepth || (depth == bestDepth && len(sub.errs) < len(best.errs)) {
+			best, bestDepth = sub, depth
+		}
+	}
+
+	if best == nil {
+		v.mismatchf(path, "expected %s, got %s", schemaExpected(schema), describeValue(value))
+		return
+	}
+	v.errs = append(v.errs, best.errs...)
+	for e := range best.mismatches {
+		v.markMismatch(e)
+	}
+}
+
+func (v *schemaValidator) validateArray(path string, schema map[string]interface{}, list []interface{}) {
+	if min, ok := schema["minItems"].(float64); ok && float64(len(list)) < min {
+		v.errorf(path, "expected at least %v items", min)
+	}
+
+	switch items := schema["items"].(type) {
+	case map[string]interface{}:
+		for i, item := range list {
+			v.validate(pathIndex(path, i), items, item)
+		}
+	case []interface{}:
+		for i, item := range list {
+			if i >= len(items) {
+				if additional, ok := schema["additionalItems"].(bool); ok && !additional {
+					v.errorf(path, "expected at most %d items", len(items))
+					return
+				}
+				continue
+			}
+			v.validate(pathIndex(path, i), items[i].(map[string]interface{}), item)
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(path string, schema map[string]interface{}, obj map[string]interface{}) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, key := range required {
+			if _, ok := obj[key.(string)]; !ok {
+				v.errorf(path, "missing '%s'", key)
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	patterns, _ := schema["patternProperties"].(map[string]interface{})
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+KEYS:
+	for _, key := range keys {
+		keyPath := pathKey(path, key)
+
+		if s, ok := properties[key].(map[string]interface{}); ok {
+			v.validate(keyPath, s, obj[key])
+			continue
+		}
+		for pattern, s := range patterns {
+			if schemaRegexp(pattern).MatchString(key) {
+				v.validate(keyPath, s.(map[string]interface{}), obj[key])
+				continue KEYS
+			}
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.errorf(keyPath, "Unknown key '%s'", key)
+			}
+		case map[string]interface{}:
+			v.validate(keyPath, additional, obj[key])
+		}
+	}
+}
+
+var (
+	schemaRegexpsMu sync.Mutex
+	schemaRegexps   = map[string]*regexp.Regexp{}
+)
+
+func schemaRegexp(pattern string) *regexp.Regexp {
+	schemaRegexpsMu.Lock()
+	defer schemaRegexpsMu.Unlock()
+	re, ok := schemaRegexps[pattern]
+	if !ok {
+		re = regexp.MustCompile(pattern)
+		schemaRegexps[pattern] = re
+	}
+	return re
+}
+
+func schemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, len(t))
+		for i, s := range t {
+			types[i] = s.(string)
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesType(types []string, value interface{}) bool {
+	for _, t := range types {
+		switch value := value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && value == math.Trunc(value)) {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var schemaTypeNames = map[string]string{
+	"null":    "null",
+	"boolean": "a boolean",
+	"number":  "a number",
+	"integer": "an integer",
+	"string":  "a string",
+	"array":   "a list",
+	"object":  "an object",
+}
+
+// schemaExpected describes what the schema expects for error messages.
+func schemaExpected(schema map[string]interface{}) string {
+	if title, ok := schema["title"].(string); ok {
+		return title
+	}
+	types := schemaTypes(schema)
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = schemaTypeNames[t]
+	}
+	if len(names) > 0 {
+		return strings.Join(names, " or ")
+	}
+	return "a different value"
+}
+
+// formatNumber formats JSON numbers without exponents.
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The zone serial modes ("serial_mode"). The default is the modification
+// time of the zone files; "hash" is derived from the zone content so all
+// servers with the same data have the same serial and "date" is a
+// YYYYMMDDnn serial that is incremented when the content changes (the
+// serials are kept in the state file from the configuration file).
+const (
+	serialModeMtime = "mtime"
+	serialModeHash  = "hash"
+	serialModeDate  = "date"
+)
+
+// zoneContentHash returns a hash of the zone data, without the serial.
+// encoding/json sorts the object keys, so the same data always has the same
+// hash.
+func zoneContentHash(objmap map[string]interface{}) string {
+	content := make(map[string]interface{}, len(objmap))
+	for k, v := range objmap {
+		if k != "serial" {
+			content[k] = v
+		}
+	}
+	js, err := json.Marshal(content)
+	if err != nil {
+		// can't happen with the decoded zone data
+		log.Printf("could not hash zone data: %s", err)
+	}
+	sum := sha256.Sum256(js)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashSerial returns the serial for the "hash" mode, a positive 31 bit
+// number from the content hash.
+func hashSerial(hash string) int {
+	sum, err := hex.DecodeString(hash)
+	if err != nil || len(sum) < 4 {
+		return 1
+	}
+	serial := int(binary.BigEndian.Uint32(sum) & 0x7fffffff)
+	if serial == 0 {
+		serial = 1
+	}
+	return serial
+}
+
+type serialState struct {
+	Hash   string `json:"hash"`
+	Serial int    `json:"serial"`
+}
+
+// serialStore has the "date" mode serials and the content hash they are
+// for, saved in a state file (if there is one) so they survive restarts.
+type serialStore struct {
+	mu       sync.Mutex
+	fileName string
+	zones    map[string]serialState
+}
+
+var zoneSerials = new(serialStore)
+
+// dateSerial returns the "date" serial for the zone content hash; the
+// serial is incremented (and the state saved, except with -checkconfig)
+// when the content changed.
+func (s *serialStore) dateSerial(fileName, zoneName, hash string, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.zones == nil || s.fileName != fileName {
+		s.fileName = fileName
+		s.zones = s.load()
+	}
+
+	state, ok := s.zones[zoneName]
+	if ok && state.Hash == hash {
+		return state.Serial
+	}
+
+	serial, _ := strconv.Atoi(now.UTC().Format("20060102") + "00")
+	if ok && state.Serial >= serial {
+		serial = state.Serial + 1
+	}
+	s.zones[zoneName] = serialState{Hash: hash, Serial: serial}
+
+	// -checkconfig doesn't change the state
+	if *flagcheckconfig {
+		return serial
+	}
+	if err := s.save(); err != nil {
+		log.Printf("Could not save the zone serials to %s: %s", s.fileName, err)
+	}
+	return serial
+}
+
+func (s *serialStore) load() map[string]serialState {
+	zones := make(map[string]serialState)
+	if len(s.fileName) == 0 {
+		return zones
+	}
+	data, err := ioutil.ReadFile(s.fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Could not read the zone serials from %s: %s", s.fileName, err)
+		}
+		return zones
+	}
+	if err := json.Unmarshal(data, &zones); err != nil {
+		log.Printf("Could not parse the zone serials in %s: %s", s.fileName, err)
+		return make(map[string]serialState)
+	}
+	return zones
+}
+
+// save writes the state file.
+func (s *serialStore) save() error {
+	if len(s.fileName) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.zones, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.fileName, append(data, '\n'))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// zonesInterval is the time between reads of the zone directory
+const zonesInterval = 5 * time.Second
+
+// A zoneSource is where zones come from: the zone directory, the primary
+// servers of the secondary zones, HTTP(S) URLs or a key-value store.
+type zoneSource interface {
+	// run reads the zones and watches them for changes; the new versions
+	// of the zones are sent to updates
+	run(updates chan<- zoneUpdate)
+}
+
+// zoneUpdate is a new version of a zone (zone is nil if the zone was
+// removed). For the zones from the zone directory dir is the directory and
+// files are the zone files (the reverse zones are read from them again when
+// they are regenerated).
+type zoneUpdate struct {
+	name  string
+	zone  *Zone
+	dir   string
+	files []string
+}
+
+// dirZoneSource reads the zone files in a directory, every interval.
+type dirZoneSource struct {
+	dir      string
+	interval time.Duration
+
+	// the zones read from the directory, so the zones of removed files
+	// are removed
+	zones map[string]bool
+}
+
+// dirZoneSource returns the source for the zone directory.
+func (srv *Server) dirZoneSource(dirName string) *dirZoneSource {
+	if srv.dirZones == nil {
+		srv.dirZones = &dirZoneSource{interval: zonesInterval, zones: make(map[string]bool)}
+	}
+	srv.dirZones.dir = dirName
+	return srv.dirZones
+}
+
+func (s *dirZoneSource) run(updates chan<- zoneUpdate) {
+	for {
+		// the errors are logged by read
+		list, _ := s.read()
+		for _, u := range list {
+			updates <- u
+		}
+		time.Sleep(s.interval)
+	}
+}
+
+// zoneSources returns the zone sources that are set up, the zone directory
+// first.
+func (srv *Server) zoneSources(dirName string) []zoneSource {
+	sources := []zoneSource{srv.dirZoneSource(dirName)}
+	for _, s := range srv.secondaries {
+		sources = append(sources, s)
+	}
+	if srv.httpZones != nil {
+		sources = append(sources, srv.httpZones)
+	}
+	if srv.kvZones != nil {
+		sources = append(sources, srv.kvZones)
+	}
+	return sources
+}
+
+func (srv *Server) zonesReader(dirName string, zones Zones) {
+	updates := make(chan zoneUpdate)
+	for _, source := range srv.zoneSources(dirName) {
+		go source.run(updates)
+	}
+
+	// all the updates are installed here, so zones is only changed from
+	// this goroutine
+	for u := range updates {
+		srv.installZone(zones, u)
+	}
+}
+
+// installZone installs (or removes) the new version of a zone from a zone
+// source. A zone from the zone directory is ignored while the zone is
+// installed from another zone source, and used again when it's removed
+// there.
+func (srv *Server) installZone(zones Zones, u zoneUpdate) {
+	if srv.externalZones == nil {
+		srv.externalZones = make(map[string]bool)
+	}
+	if srv.zonePaths == nil {
+		srv.zonePaths = make(map[string][]string)
+	}
+
+	zone := u.zone
+	// the zone is from (or back to) the zone directory
+	fromDir := len(u.dir) > 0
+
+	switch {
+	case fromDir:
+		if zone != nil {
+			srv.zonePaths[u.name] = u.files
+		} else {
+			delete(srv.zonePaths, u.name)
+		}
+		if srv.isExternalZone(u.name) {
+			if zone != nil {
+				logPrintf("Ignoring %s, %s is from another zone source\n", strings.Join(u.files, " "), u.name)
+			}
+			return
+		}
+	case zone != nil:
+		if len(zone.Options.Reverse) > 0 {
+			log.Printf("%s: the reverse option only works for zones in the zone directory, no PTR records are added", u.name)
+		}
+		srv.externalZones[u.name] = true
+	case srv.externalZones[u.name]:
+		delete(srv.externalZones, u.name)
+		if files, ok := srv.zonePaths[u.name]; ok {
+			// the zone file is used again
+			var err error
+			zone, err = readZoneFile(u.name, files...)
+			if zone == nil || err != nil {
+				log.Printf("Error reading zone '%s': %s", u.name, err)
+				zone = nil
+			}
+			fromDir = true
+		}
+	default:
+		return
+	}
+
+	pending := Zones{}
+	switch {
+	case zone == nil:
+		if old, ok := zones[u.name]; ok {
+			log.Println("Removing zone", old.Origin)
+			old.Close()
+			dns.HandleRemove(u.name)
+			delete(zones, u.name)
+		}
+	case fromDir && len(zone.Options.Reverse) > 0:
+		// the PTR records are added by setupReverseZones
+		pending[u.name] = zone
+	default:
+		srv.addHandler(zones, u.name, zone)
+	}
+	srv.setupReverseZones(zones, pending, map[string]bool{u.name: true}, srv.zonePaths)
+}
+
+// isExternalZone returns true if the zone is from a zone source other than
+// the zone directory.
+func (srv *Server) isExternalZone(zoneName string) bool {
+	return srv.isSecondary(zoneName) || srv.externalZones[zoneName]
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+	. "gopkg.in/check.v1"
+)
+
+type ZoneSourceSuite struct {
+}
+
+var _ = Suite(&ZoneSourceSuite{})
+
+func (s *ZoneSourceSuite) TestDirZoneSource(c *C) {
+	dir := c.MkDir()
+	mtime := time.Now()
+	writeZone := func(name, data string) {
+		fileName := dir + "/" + name + ".json"
+		c.Assert(ioutil.WriteFile(fileName, []byte(data), 0644), IsNil)
+		mtime = mtime.Add(time.Minute)
+		c.Assert(os.Chtimes(fileName, mtime, mtime), IsNil)
+	}
+	ptr := func(zones Zones) string {
+		label := zones["2.0.192.in-addr.arpa"].Labels["1"]
+		if label == nil || len(label.Records[dns.TypePTR]) == 0 {
+			return ""
+		}
+		return label.firstRR(dns.TypePTR).(*dns.PTR).Ptr
+	}
+	defer dns.HandleRemove("source.example.com")
+	defer dns.HandleRemove("2.0.192.in-addr.arpa")
+
+	writeZone("source.example.com", `{ "serial": 1, "data": { "www": { "a": [ "192.0.2.1" ] } } }`)
+	writeZone("2.0.192.in-addr.arpa", `{ "reverse": "source.example.com", "data": { "": { "ns": "ns1.example.net" } } }`)
+
+	srv := &Server{}
+	source := srv.dirZoneSource(dir)
+
+	// a zoneUpdate for each zone, the reverse zones last
+	updates, err := source.read()
+	c.Assert(err, IsNil)
+	c.Assert(updates, HasLen, 2)
+	c.Check(updates[0].name, Equals, "source.example.com")
+	c.Check(updates[0].dir, Equals, dir)
+	c.Check(updates[0].files, DeepEquals, []string{dir + "/source.example.com.json"})
+	c.Check(updates[1].name, Equals, "2.0.192.in-addr.arpa")
+
+	zones := make(Zones)
+	for _, u := range updates {
+		srv.installZone(zones, u)
+	}
+	c.Check(ptr(zones), Equals, "www.source.example.com.")
+
+	updates, err = source.read()
+	c.Assert(err, IsNil)
+	c.Check(updates, HasLen, 0)
+
+	// a zone from another source takes precedence over the zone file
+	external := NewZone("source.example.com")
+	external.AddLabel("mail").Records[dns.TypeA] = Records{{RR: &dns.A{
+		Hdr: dns.RR_Header{Name: "mail.source.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+		A:   []byte{192, 0, 2, 1}}}}
+	srv.installZone(zones, zoneUpdate{name: "source.example.com", zone: external})
+	c.Check(zones["source.example.com"], Equals, external)
+	c.Check(ptr(zones), Equals, "mail.source.example.com.")
+
+	writeZone("source.example.com", `{ "serial": 2, "data": { "web": { "a": [ "192.0.2.1" ] } } }`)
+	c.Check(srv.zonesReadDir(dir, zones), IsNil)
+	c.Check(zones["source.example.com"], Equals, external)
+
+	// and the zone file is used again when it's removed there
+	srv.installZone(zones, zoneUpdate{name: "source.example.com"})
+	c.Assert(zones["source.example.com"], NotNil)
+	c.Check(zones["source.example.com"].Options.Serial, Equals, 2)
+	c.Check(ptr(zones), Equals, "web.source.example.com.")
+
+	// removed zone files
+	c.Assert(os.Remove(dir+"/source.example.com.json"), IsNil)
+	updates, err = source.read()
+	c.Assert(err, IsNil)
+	c.Assert(updates, HasLen, 1)
+	c.Check(updates[0].name, Equals, "source.example.com")
+	c.Check(updates[0].zone, IsNil)
+	srv.installZone(zones, updates[0])
+	_, ok := zones["source.example.com"]
+	c.Check(ok, Equals, false)
+	c.Check(ptr(zones), Equals, "")
+}
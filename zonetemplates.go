@@ -0,0 +1,206 @@
+package main
+
+// Record set templates are defined in the "templates" section of a zone
+// with the same syntax as the labels in "data", and used from labels with
+// "template":
+//
+//	"templates": {
+//	  "web": { "a": [ [ "192.0.2.1", 10 ], [ "192.0.2.2", 10 ] ] }
+//	},
+//	"data": {
+//	  "www": { "template": "web" },
+//	  "www.europe": {
+//	    "template": "web",
+//	    "extend": { "a": [ [ "192.0.2.3", 20 ] ] },
+//	    "weights": { "192.0.2.1": 5 }
+//	  }
+//	}
+//
+// Record types set in the label replace the records from the template,
+// "extend" adds records and "weights" changes the weights of the records
+// with the "value" or [ "value", weight ] syntax. The templates are expanded
+// into ordinary labels before the records are set up.
+
+import "math"
+
+// template keys in labels, they aren't record types
+var templateKeys = map[string]bool{
+	"template": true,
+	"extend":   true,
+	"weights":  true,
+}
+
+// records with the [ "value", weight ] syntax that can be re-weighted
+var weightedTypes = map[string]bool{
+	"a":     true,
+	"aaaa":  true,
+	"ptr":   true,
+	"cname": true,
+	"aname": true,
+}
+
+func (l *zoneLoader) expandTemplates(templates, data map[string]interface{}) {
+	for _, name := range sortedKeys(templates) {
+		path := pathKey("templates", name)
+		tmpl, ok := l.toObject(path, templates[name])
+		if !ok {
+			delete(templates, name)
+			continue
+		}
+		for _, key := range []string{"template", "extend", "weights"} {
+			if _, ok := tmpl[key]; ok {
+				l.errorf(pathKey(path, key), "Templates can't use '%s'", key)
+			}
+		}
+	}
+
+	for _, dk := range sortedKeys(data) {
+		labelPath := pathKey("data", dk)
+		label, ok := data[dk].(map[string]interface{})
+		if !ok {
+			// reported by setupZoneData
+			continue
+		}
+		if label["template"] == nil && label["extend"] == nil && label["weights"] == nil {
+			continue
+		}
+		data[dk] = l.expandLabel(labelPath, templates, label)
+	}
+}
+
+func (l *zoneLoader) expandLabel(path string, templates, label map[string]interface{}) map[string]interface{} {
+	expanded := make(map[string]interface{})
+
+	if label["template"] != nil {
+		names, err := stringList(label["template"])
+		if err != nil {
+			l.errorf(pathKey(path, "template"), "%s", err)
+		}
+		for _, name := range names {
+			tmpl, ok := templates[name].(map[string]interface{})
+			if !ok {
+				l.errorf(pathKey(path, "template"), "Unknown template '%s'", name)
+				continue
+			}
+			// the records from multiple templates are combined
+			for _, key := range sortedKeys(tmpl) {
+				if templateKeys[key] {
+					continue
+				}
+				if rrs, ok := recordList(key, tmpl[key]); ok {
+					if current, ok := expanded[key].([]interface{}); ok {
+						rrs = append(append([]interface{}{}, current...), rrs...)
+					}
+					expanded[key] = rrs
+				} else {
+					expanded[key] = tmpl[key]
+				}
+			}
+		}
+	}
+
+	for key, v := range label {
+		if !templateKeys[key] {
+			expanded[key] = v
+		}
+	}
+
+	if label["extend"] != nil {
+		extendPath := pathKey(path, "extend")
+		if extend, ok := l.toObject(extendPath, label["extend"]); ok {
+			for _, key := range sortedKeys(extend) {
+				rrs, ok := recordList(key, extend[key])
+				if !ok {
+					l.errorf(pathKey(extendPath, key), "Only records can be added with extend")
+					continue
+				}
+				if current, ok := recordList(key, expanded[key]); ok {
+					rrs = append(append([]interface{}{}, current...), rrs...)
+				}
+				expanded[key] = rrs
+			}
+		}
+	}
+
+	if label["weights"] != nil {
+		weightsPath := pathKey(path, "weights")
+		if weights, ok := l.toObject(weightsPath, label["weights"]); ok {
+			l.reweight(weightsPath, expanded, weights)
+		}
+	}
+
+	return expanded
+}
+
+// reweight replaces the weights of the records in label with the values in
+// weights (by record value).
+func (l *zoneLoader) reweight(path string, label, weights map[string]interface{}) {
+	used := make(map[string]bool)
+
+	for _, key := range sortedKeys(label) {
+		if !weightedTypes[key] {
+			continue
+		}
+		rrs, ok := recordList(key, label[key])
+		if !ok {
+			continue
+		}
+		reweighted := make([]interface{}, len(rrs))
+		for i, rr := range rrs {
+			reweighted[i] = rr
+			var value string
+			switch rr := rr.(type) {
+			case string:
+				value = rr
+			case []interface{}:
+				if len(rr) > 0 {
+					value, _ = rr[0].(string)
+				}
+			}
+			w, ok := weights[value]
+			if !ok {
+				continue
+			}
+			used[value] = true
+			if weight, ok := l.toIntRange(pathKey(path, value), w, math.MaxInt32); ok {
+				rec := []interface{}{value, float64(weight)}
+				if list, ok := rr.([]interface{}); ok && len(list) > 2 {
+					// keep the TTL
+					rec = append(rec, list[2:]...)
+				}
+				reweighted[i] = rec
+			}
+		}
+		label[key] = reweighted
+	}
+
+	for _, value := range sortedKeys(weights) {
+		if !used[value] {
+			l.warnf(pathKey(path, value), "No records for '%s' to re-weight", value)
+		}
+	}
+}
+
+// recordList returns the records for the record type key as a list (a
+// single record can be given without the list).
+func recordList(key string, v interface{}) ([]interface{}, bool) {
+	if _, ok := recordTypes[key]; !ok {
+		return nil, false
+	}
+	switch v := v.(type) {
+	case nil:
+		return []interface{}{}, true
+	case []interface{}:
+		return v, true
+	case map[string]interface{}:
+		if key == "ns" {
+			// map syntax, map[ns2.example.net:<nil> ns1.example.net:<nil>]
+			list := []interface{}{}
+			for _, ns := range sortedKeys(v) {
+				list = append(list, ns)
+			}
+			return list, true
+		}
+	}
+	return []interface{}{v}, true
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abh/geodns/querylog"
+	"github.com/miekg/dns"
+)
+
+// Zone transfers (AXFR, over TCP) are enabled for a zone with the
+// "transfer" option:
+//
+//	"transfer": {
+//	  "allow": [ "192.0.2.0/24", "2001:db8::53" ],
+//	  "notify": [ "192.0.2.53", "[2001:db8::53]:5353" ],
+//	  "tsig": "transfer-key.",
+//	  "view": [ "europe", "@" ]
+//	}
+//
+// Transfers are allowed from the "allow" addresses and networks and/or
+// with one of the "tsig" keys (the secrets are in the configuration file);
+// with both the client needs both. The transferred zone is generated from
+// the loaded zone and has the answers a client with the "view" targets
+// would get (the global "@" answers by default). IXFR requests get the
+// changes since the serial of the secondary when they are in the zone
+// history and the "notify" servers get a NOTIFY when the zone is loaded.
+
+type ZoneTransfer struct {
+	Allow  []*net.IPNet
+	Notify []string
+	Keys   []string
+	View   []string
+}
+
+// transferMsgSize is how big (uncompressed) the messages of a zone transfer
+// can get before the next records go in a new message
+const transferMsgSize = 32768
+
+func (l *zoneLoader) setupTransfer(path string, v interface{}) *ZoneTransfer {
+	obj, ok := l.toObject(path, v)
+	if !ok {
+		return nil
+	}
+
+	t := &ZoneTransfer{View: []string{"@"}}
+
+	for _, key := range sortedKeys(obj) {
+		keyPath := pathKey(path, key)
+		list, err := stringList(obj[key])
+		if err != nil {
+			l.errorf(keyPath, "%s", err)
+			continue
+		}
+		switch key {
+		case "allow":
+			for i, s := range list {
+				s = strings.TrimSpace(s)
+				if ip := net.ParseIP(s); ip != nil {
+					if ip.To4() != nil {
+						s = s + "/32"
+					} else {
+						s = s + "/128"
+					}
+				}
+				_, network, err := net.ParseCIDR(s)
+				if err != nil {
+					l.errorf(pathIndex(keyPath, i), "Bad address or network '%s'", s)
+					continue
+				}
+				t.Allow = append(t.Allow, network)
+			}
+		case "notify":
+			for i, s := range list {
+				addr, err := dnsAddr(strings.TrimSpace(s))
+				if err != nil {
+					l.errorf(pathIndex(keyPath, i), "%s", err)
+					continue
+				}
+				t.Notify = append(t.Notify, addr)
+			}
+		case "tsig":
+			for _, s := range list {
+				t.Keys = append(t.Keys, dns.Fqdn(strings.ToLower(strings.TrimSpace(s))))
+			}
+		case "view":
+			view := make([]string, 0, len(list)+1)
+			for _, s := range list {
+				if s = strings.ToLower(strings.TrimSpace(s)); len(s) > 0 {
+					view = append(view, s)
+				}
+			}
+			// like the targets for queries, the global answers are last
+			if len(view) == 0 || view[len(view)-1] != "@" {
+				view = append(view, "@")
+			}
+			t.View = view
+		default:
+			l.warnf(keyPath, "Unknown transfer option '%s'", key)
+		}
+	}
+
+	if len(t.Allow) == 0 && len(t.Keys) == 0 {
+		l.errorf(path, "Zone transfers need 'allow' or 'tsig' (or both)")
+		return nil
+	}
+	return t
+}
+
+// dnsAddr returns the host:port address for a DNS server (like a "notify"
+// server), the port is 53 if it's not set.
+func dnsAddr(s string) (string, error) {
+	if net.ParseIP(s) != nil {
+		return net.JoinHostPort(s, "53"), nil
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		if len(s) == 0 || strings.Contains(s, ":") {
+			return "", fmt.Errorf("Bad address '%s'", s)
+		}
+		return net.JoinHostPort(s, "53"), nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || len(host) == 0 {
+		return "", fmt.Errorf("Bad address '%s'", s)
+	}
+	return s, nil
+}
+
+func (t *ZoneTransfer) allowedIP(ip net.IP) bool {
+	if len(t.Allow) == 0 {
+		return true
+	}
+	for _, network := range t.Allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *ZoneTransfer) hasKey(name string) bool {
+	name = strings.ToLower(name)
+	for _, key := range t.Keys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// serveTransfer answers an AXFR or IXFR request for the zone.
+func (srv *Server) serveTransfer(w dns.ResponseWriter, req *dns.Msg, z *Zone, ip net.IP, qle *querylog.Entry) {
+	t := z.Options.Transfer
+	qtype := req.Question[0].Qtype
+	qtypeStr := dns.TypeToString[qtype]
+
+	// the serial of the secondary for IXFR
+	var serial *dns.SOA
+	if qtype == dns.TypeIXFR && len(req.Ns) > 0 {
+		serial, _ = req.Ns[0].(*dns.SOA)
+	}
+
+	// the request is signed with a key we know (and the signature is
+	// good), so the answers are signed, too
+	tsig := req.IsTsig()
+	if tsig != nil {
+		if _, ok := srv.tsigSecrets[strings.ToLower(tsig.Hdr.Name)]; !ok || w.TsigStatus() != nil {
+			tsig = nil
+		}
+	}
+
+	rcode := dns.RcodeSuccess
+	_, tcp := w.RemoteAddr().(*net.TCPAddr)
+	switch {
+	case t == nil || (!tcp && qtype == dns.TypeAXFR) || !t.allowedIP(ip):
+		rcode = dns.RcodeRefused
+	case len(t.Keys) > 0 && (tsig == nil || !t.hasKey(tsig.Hdr.Name)):
+		rcode = dns.RcodeNotAuth
+	case qtype == dns.TypeIXFR && serial == nil:
+		rcode = dns.RcodeFormatError
+	}
+
+	if qle != nil {
+		qle.Rcode = rcode
+	}
+
+	if rcode != dns.RcodeSuccess {
+		log.Printf("[zone %s] refused %s from %s", z.Origin, qtypeStr, w.RemoteAddr())
+		m := new(dns.Msg)
+		m.SetRcode(req, rcode)
+		w.WriteMsg(m)
+		return
+	}
+
+	var rrs []dns.RR
+	switch {
+	case qtype == dns.TypeAXFR:
+		rrs = z.transferRecords(t.View)
+	case !tcp:
+		// over UDP just the current SOA, the secondary can then
+		// ask again over TCP (RFC 1995, section 2)
+		rrs = []dns.RR{z.SoaRR()}
+	default:
+		rrs = z.ixfrRecords(serial.Serial)
+	}
+	log.Printf("[zone %s] %s to %s (%d records)", z.Origin, qtypeStr, w.RemoteAddr(), len(rrs))
+	if qle != nil {
+		qle.Answers = len(rrs)
+	}
+
+	for len(rrs) > 0 {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Authoritative = true
+
+		n := 0
+		for ; n < len(rrs); n++ {
+			m.Answer = append(m.Answer, rrs[n])
+			if n > 0 && m.Len() > transferMsgSize {
+				m.Answer = m.Answer[:n]
+				break
+			}
+		}
+		rrs = rrs[n:]
+
+		if tsig != nil {
+			m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+		}
+		if err := w.WriteMsg(m); err != nil {
+			log.Printf("[zone %s] %s to %s failed: %s", z.Origin, qtypeStr, w.RemoteAddr(), err)
+			return
+		}
+		// the messages after the first are signed with just the timers
+		// (RFC 2845, section 4.4)
+		w.TsigTimersOnly(true)
+	}
+}
+
+// transferRecords returns the records of the zone for a zone transfer: the
+// SOA record first and last and in between the records for each name that a
+// client with the view targets would get (all of them, rather than just
+// max_hosts of them). Targeted labels like "www.europe" aren't names in the
+// zone, they're only used through the view.
+func (z *Zone) transferRecords(view []string) []dns.RR {
+	soa := z.SoaRR()
+	rrs := []dns.RR{soa}
+
+	nameSet := make(map[string]bool)
+	seenTypes := make(map[uint16]bool)
+	for name, label := range z.Labels {
+		if base, ok := z.Options.Targeting.targetedLabel(name); ok {
+			name = base
+		}
+		nameSet[name] = true
+		for qtype := range label.Records {
+			seenTypes[qtype] = true
+		}
+	}
+	names := make([]string, 0, len(nameSet))
+	for name := range nameSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, label := range z.GlobLabels {
+		for qtype := range label.Records {
+			seenTypes[qtype] = true
+		}
+	}
+
+	var qtypes []int
+	for qtype := range seenTypes {
+		switch qtype {
+		case dns.TypeSOA, dns.TypeCNAME, dns.TypeMF, dns.TypeMD:
+			// aliases and ANAMEs are only used internally (an
+			// ANAME is transferred as its fallback records)
+		default:
+			qtypes = append(qtypes, int(qtype))
+		}
+	}
+	sort.Ints(qtypes)
+
+	for _, name := range names {
+		fqdn := z.Origin + "."
+		if len(name) > 0 {
+			fqdn = name + "." + fqdn
+		}
+
+		var nameRRs []dns.RR
+		var cname dns.RR
+		for _, qtype := range qtypes {
+			label, found := z.findLabels(name, view, qTypes{dns.TypeMF, dns.TypeCNAME, uint16(qtype)})
+			switch found {
+			case dns.TypeCNAME:
+				// the highest weight CNAME, as a name with a CNAME
+				// can't have other records
+				if cname == nil {
+					cname = highestWeight(label.Records[dns.TypeCNAME]).RR
+				}
+			case uint16(qtype):
+				nameRRs = append(nameRRs, transferRRs(label, found, fqdn)...)
+			}
+		}
+		if cname != nil {
+			rr := dns.Copy(cname)
+			rr.Header().Name = fqdn
+			nameRRs = []dns.RR{rr}
+		}
+		rrs = append(rrs, nameRRs...)
+	}
+
+	// wildcard labels (other glob patterns can't be transferred)
+	for _, label := range z.GlobLabels {
+		if strings.Count(label.Label, "*") != 1 || (label.Label != "*" && !strings.HasPrefix(label.Label, "*.")) {
+			continue
+		}
+		fqdn := label.Label + "." + z.Origin + "."
+		if len(label.Records[dns.TypeCNAME]) > 0 {
+			rr := dns.Copy(highestWeight(label.Records[dns.TypeCNAME]).RR)
+			rr.Header().Name = fqdn
+			rrs = append(rrs, rr)
+			continue
+		}
+		for _, qtype := range qtypes {
+			if len(label.Records[uint16(qtype)]) > 0 {
+				rrs = append(rrs, transferRRs(label, uint16(qtype), fqdn)...)
+			}
+		}
+	}
+
+	return append(rrs, soa)
+}
+
+// highestWeight returns the record with the highest weight (the first of
+// them if there are more).
+func highestWeight(records Records) Record {
+	best := records[0]
+	for _, r := range records[1:] {
+		if r.Weight > best.Weight {
+			best = r
+		}
+	}
+	return best
+}
+
+// transferRRs returns copies of the qtype records of label (without the
+// ones with weight 0 in a weighted set, as they are never returned) named
+// fqdn.
+func transferRRs(label *Label, qtype uint16, fqdn string) []dns.RR {
+	var records Records
+	for _, r := range label.Records[qtype] {
+		if label.Weight[qtype] > 0 && r.Weight == 0 {
+			continue
+		}
+		records = append(records, r)
+	}
+	rrs := make([]dns.RR, 0, len(records))
+	for _, r := range sameTtl(records) {
+		rr := dns.Copy(r.RR)
+		rr.Header().Name = fqdn
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}